@@ -9,6 +9,8 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+// The writer and reader interfaces grew Flush, Sync, ReadRaw and WriteRaw, implementations outside
+// this package must provide them.
 
 type JsonWriter interface {
 
@@ -16,6 +18,10 @@ type JsonWriter interface {
 
     Write(object interface{}) error
 
+	Flush() error
+
+	Sync() error
+
 	Close() error
 
 }
@@ -36,6 +42,12 @@ type ProtoWriter interface {
 
 	Write(message proto.Message) ([]byte, error)
 
+	WriteRaw(blob []byte) error
+
+	Flush() error
+
+	Sync() error
+
 	Close() error
 
 }
@@ -45,6 +57,30 @@ type ProtoReader interface {
 
 	ReadTo(message proto.Message) error
 
+	ReadRaw() ([]byte, error)
+
+	Close() error
+
+}
+
+
+type ProtoJsonWriter interface {
+
+	Write(message proto.Message) error
+
+	Flush() error
+
+	Sync() error
+
+	Close() error
+
+}
+
+
+type ProtoJsonReader interface {
+
+	ReadTo(message proto.Message) error
+
 	Close() error
 
 }
@@ -56,6 +92,10 @@ type CsvWriter interface {
 
 	Write(values ...string) error
 
+	Flush() error
+
+	Sync() error
+
 	Close() error
 
 }
@@ -101,4 +141,60 @@ type CsvFile interface {
 
 	Next() (CsvRecord, error)
 
-}
\ No newline at end of file
+}
+
+type RecordReader interface {
+
+	Header() []string
+
+	Read() (Record, error)
+
+	Close() error
+}
+
+type RecordWriter interface {
+
+	Write(record Record) error
+
+	Flush() error
+
+	Close() error
+}
+
+// AtomicCsvWriter, AtomicJsonWriter, AtomicProtoWriter and AtomicRecordWriter write to a temp file,
+// Commit syncs and renames it into place, Abort removes it. Close commits, or aborts after a write error.
+type AtomicCsvWriter interface {
+
+	CsvWriter
+
+	Commit() error
+
+	Abort() error
+}
+
+type AtomicJsonWriter interface {
+
+	JsonWriter
+
+	Commit() error
+
+	Abort() error
+}
+
+type AtomicProtoWriter interface {
+
+	ProtoWriter
+
+	Commit() error
+
+	Abort() error
+}
+
+type AtomicRecordWriter interface {
+
+	RecordWriter
+
+	Commit() error
+
+	Abort() error
+}
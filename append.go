@@ -0,0 +1,207 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// openAppendFile opens the file for appending, creates it when missing and validates that its last record is complete.
+// Gzip files are decompressed to the end for the check, since a crash leaves a truncated member behind.
+func openAppendFile(filePath string, format *Format, gzipEnabled bool, header []string) (fd *os.File, empty bool, err error) {
+
+	fd, err = os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, false, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+
+	err = func() error {
+		fi, err := fd.Stat()
+		if err != nil {
+			return err
+		}
+		if empty = fi.Size() == 0; empty {
+			return syncCreated(filePath)
+		}
+		if header != nil {
+			if err := checkAppendHeader(fd, fi.Size(), format, gzipEnabled, header); err != nil {
+				return err
+			}
+		}
+		return checkAppendTail(fd, fi.Size(), format.Kind, gzipEnabled)
+	}()
+
+	if err != nil {
+		fd.Close()
+		return nil, false, errors.Errorf("can not append to '%s', %v", filePath, err)
+	}
+	return fd, empty, nil
+}
+
+func checkAppendHeader(fd *os.File, size int64, format *Format, gzipEnabled bool, header []string) error {
+	reader, err := format.Open(io.NewSectionReader(fd, 0, size), gzipEnabled)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if existing := reader.Header(); !sameHeader(existing, header) {
+		return errors.Errorf("header %v does not match the existing header %v", header, existing)
+	}
+	return nil
+}
+
+func checkAppendTail(fd *os.File, size int64, kind string, gzipEnabled bool) error {
+
+	if !gzipEnabled && kind != ProtoRecords {
+		var last [1]byte
+		if _, err := fd.ReadAt(last[:], size-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			return errors.New("last record is not terminated by newline")
+		}
+		return nil
+	}
+
+	var r io.Reader = bufio.NewReaderSize(io.NewSectionReader(fd, 0, size), FileRWBlockSize)
+	if gzipEnabled {
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Errorf("gzip read error, %v", err)
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	if kind == ProtoRecords {
+		return checkProtoFrames(r)
+	}
+	return checkNewlineEnd(r)
+}
+
+func checkNewlineEnd(r io.Reader) error {
+	buf := make([]byte, FileRWBlockSize)
+	var last byte = '\n'
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if last != '\n' {
+		return errors.New("last record is not terminated by newline")
+	}
+	return nil
+}
+
+func checkProtoFrames(r io.Reader) error {
+	var lenBuf [4]byte
+	for pos := int64(0); ; pos++ {
+		_, err := io.ReadFull(r, lenBuf[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			n := int64(binary.BigEndian.Uint32(lenBuf[:]))
+			var cnt int64
+			if cnt, err = io.CopyN(ioutil.Discard, r, n); err == io.EOF && cnt < n {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err == io.ErrUnexpectedEOF {
+			return errors.Errorf("truncated frame of record %d", pos+1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AppendCsvFile opens the csv file for appending, a missing file is created like by NewCsvFile.
+// A non-nil header is written to an empty file and must match the header of an existing file.
+func AppendCsvFile(filePath string, header []string, valueProcessors ...CsvValueProcessor) (CsvWriter, error) {
+
+	gzipEnabled := strings.HasSuffix(filePath, ".gz")
+	fd, empty, err := openAppendFile(filePath, CsvFormat, gzipEnabled, header)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newCsvFileWriter(fd, gzipEnabled, ',', valueProcessors)
+	if empty && header != nil {
+		if err := t.csvw.Write(header); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AppendJsonFile opens the json file for appending, gzip files get a new member.
+func AppendJsonFile(filePath string) (JsonWriter, error) {
+
+	format, gzipEnabled := kindFormatOf(JsonRecords, filePath)
+	fd, _, err := openAppendFile(filePath, format, gzipEnabled, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newJsonFileWriter(fd, gzipEnabled, JsonFormatOf(filePath)), nil
+}
+
+// AppendProtoFile opens the proto file for appending after checking the frames of the existing records.
+func AppendProtoFile(filePath string) (ProtoWriter, error) {
+
+	fd, _, err := openAppendFile(filePath, ProtoFormat, strings.HasSuffix(filePath, ".gz"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newProtoFileWriter(fd, strings.HasSuffix(filePath, ".gz")), nil
+}
+
+// AppendFile opens any supported file for appending like AppendCsvFile, formats are selected by extension.
+func AppendFile(filePath string, header []string) (RecordWriter, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.AppendFile(filePath, gzipEnabled, header)
+}
+
+func (t *Format) AppendFile(filePath string, gzipEnabled bool, header []string) (RecordWriter, error) {
+
+	fd, empty, err := openAppendFile(filePath, t, gzipEnabled, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !empty {
+		header = nil
+	}
+
+	fw := bufio.NewWriterSize(fd, FileRWBlockSize)
+
+	writer, err := t.Create(fw, gzipEnabled, header)
+	if err != nil {
+		fd.Close()
+		return nil, errors.Errorf("append %s records in '%s', %v", t.Name, filePath, err)
+	}
+
+	return &fileRecordWriter{writer, fw, fd}, nil
+}
@@ -0,0 +1,96 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAppendCsvFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "append-test") + ".csv.gz"
+	defer os.Remove(filePath)
+
+	header := []string{"name", "count"}
+	for _, row := range [][]string{{"a", "1"}, {"b", "2"}} {
+		writer, err := files.AppendCsvFile(filePath, header)
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(row...))
+		require.NoError(t, writer.Close())
+	}
+
+	require.Equal(t, [][]string{header, {"a", "1"}, {"b", "2"}}, readCsvRows(t, filePath))
+
+	_, err := files.AppendCsvFile(filePath, []string{"name", "total"})
+	require.Error(t, err)
+}
+
+func TestAppendFileValidatesTail(t *testing.T) {
+
+	filePath := tempFilePath(t, "append-test")
+
+	jsonFilePath := filePath + ".jsonl"
+	defer os.Remove(jsonFilePath)
+	require.NoError(t, ioutil.WriteFile(jsonFilePath, []byte("{\"a\":1}\n{\"a\":"), 0644))
+	_, err := files.AppendJsonFile(jsonFilePath)
+	require.Error(t, err)
+
+	protoFilePath := filePath + ".pb"
+	defer os.Remove(protoFilePath)
+	require.NoError(t, ioutil.WriteFile(protoFilePath, []byte{0, 0, 0, 3, 1, 2, 3, 0, 0, 0, 5, 1}, 0644))
+	_, err = files.AppendProtoFile(protoFilePath)
+	require.Error(t, err)
+
+	gzFilePath := filePath + ".jsonl.gz"
+	defer os.Remove(gzFilePath)
+	writer, err := files.NewJsonFile(gzFilePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteRaw([]byte(`{"a":1}`)))
+	require.NoError(t, writer.Close())
+
+	content, err := ioutil.ReadFile(gzFilePath)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(gzFilePath, content[:len(content)-4], 0644))
+	_, err = files.AppendJsonFile(gzFilePath)
+	require.Error(t, err)
+}
+
+func TestAppendProtoFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "append-test") + ".pb.gz"
+	defer os.Remove(filePath)
+
+	for i := 0; i < 2; i++ {
+		writer, err := files.AppendProtoFile(filePath)
+		require.NoError(t, err)
+		require.NoError(t, writer.WriteRaw([]byte{byte(i)}))
+		require.NoError(t, writer.Close())
+	}
+
+	cnt, err := files.CountFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+}
+
+func TestAppendFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "append-test") + ".tsv"
+	defer os.Remove(filePath)
+
+	for _, name := range []string{"a", "b"} {
+		writer, err := files.AppendFile(filePath, []string{"name"})
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(files.Record{Values: []string{name}}))
+		require.NoError(t, writer.Close())
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "name\na\nb\n", string(content))
+}
@@ -0,0 +1,342 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// atomicFile writes the temp file in the directory of the target, the target is replaced only by commit.
+type atomicFile struct {
+	filePath string
+	tempPath string
+	fd       *os.File
+	fw       *bufio.Writer
+	err      error // first write error, Close removes the temp file after it
+	closed   bool
+}
+
+func createAtomicFile(filePath string) (*atomicFile, error) {
+
+	dir, name := filepath.Split(filePath)
+	if dir == "" {
+		dir = "." // not the default temp dir, the rename must stay in the target directory
+	}
+	fd, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err == nil {
+		// TempFile creates the file private, the committed file gets the mode of created files
+		if err = fd.Chmod(0644); err != nil {
+			fd.Close()
+			os.Remove(fd.Name())
+		}
+	}
+	if err != nil {
+		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
+	}
+	return &atomicFile{
+		filePath: filePath,
+		tempPath: fd.Name(),
+		fd:       fd,
+		fw:       bufio.NewWriterSize(fd, FileRWBlockSize),
+	}, nil
+}
+
+func (t *atomicFile) Write(p []byte) (int, error) {
+	n, err := t.fw.Write(p)
+	t.fail(err)
+	return n, err
+}
+
+// Sync commits the written data of the temp file to disk, atomic writers call it from their Sync.
+func (t *atomicFile) Sync() error {
+	return t.fail(flushLayers(t.fw.Flush, t.fd.Sync))
+}
+
+func (t *atomicFile) fail(err error) error {
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return err
+}
+
+// commit publishes the temp file after the writer closed with closeErr, on any error the temp file is removed.
+func (t *atomicFile) commit(closeErr error) error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	err := closeErr
+	if err == nil {
+		err = t.err
+	}
+	if flushErr := t.fw.Flush(); err == nil {
+		err = flushErr
+	}
+	if err == nil {
+		err = t.fd.Sync()
+	}
+	if closeErr := t.fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(t.tempPath, t.filePath)
+	}
+	if err == nil {
+		err = syncCreated(t.filePath)
+	}
+
+	if err != nil {
+		os.Remove(t.tempPath)
+		return errors.Errorf("file commit error '%s', %v", t.filePath, err)
+	}
+	return nil
+}
+
+func (t *atomicFile) abort() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	t.fd.Close()
+	return os.Remove(t.tempPath)
+}
+
+// close commits the file, or removes it after a write error and returns that error.
+func (t *atomicFile) close(closeFn func() error) error {
+	if t.err != nil {
+		t.abort()
+		return t.err
+	}
+	return t.commit(closeFn())
+}
+
+type atomicCsvWriter struct {
+	*csvStreamWriter
+	f *atomicFile
+}
+
+// NewAtomicCsvFile writes the csv file to a temp file in the same directory, Commit or Close renames it into place.
+func NewAtomicCsvFile(filePath string, valueProcessors ...CsvValueProcessor) (AtomicCsvWriter, error) {
+
+	f, err := createAtomicFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newCsvStream(f, strings.HasSuffix(filePath, ".gz"), ',', valueProcessors)
+	return &atomicCsvWriter{w, f}, nil
+}
+
+func (t *atomicCsvWriter) Write(values ...string) error {
+	return t.f.fail(t.csvStreamWriter.Write(values...))
+}
+
+func (t *atomicCsvWriter) Flush() error {
+	return t.f.fail(flushLayers(t.csvStreamWriter.Flush, t.f.fw.Flush))
+}
+
+func (t *atomicCsvWriter) Sync() error {
+	return t.f.fail(t.csvStreamWriter.Sync())
+}
+
+func (t *atomicCsvWriter) Commit() error {
+	return t.f.commit(t.csvStreamWriter.Close())
+}
+
+func (t *atomicCsvWriter) Abort() error {
+	return t.f.abort()
+}
+
+func (t *atomicCsvWriter) Close() error {
+	return t.f.close(t.csvStreamWriter.Close)
+}
+
+type atomicJsonWriter struct {
+	JsonWriter
+	f *atomicFile
+}
+
+func NewAtomicJsonFile(filePath string) (AtomicJsonWriter, error) {
+	return NewAtomicJsonFileFormat(filePath, JsonFormatOf(filePath))
+}
+
+func NewAtomicJsonFileFormat(filePath string, format JsonFormat) (AtomicJsonWriter, error) {
+
+	f, err := createAtomicFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicJsonWriter{NewJsonStreamFormat(f, strings.HasSuffix(filePath, ".gz"), format), f}, nil
+}
+
+func (t *atomicJsonWriter) WriteRaw(message json.RawMessage) error {
+	return t.f.fail(t.JsonWriter.WriteRaw(message))
+}
+
+func (t *atomicJsonWriter) Write(object interface{}) error {
+	return t.f.fail(t.JsonWriter.Write(object))
+}
+
+func (t *atomicJsonWriter) Flush() error {
+	return t.f.fail(flushLayers(t.JsonWriter.Flush, t.f.fw.Flush))
+}
+
+func (t *atomicJsonWriter) Sync() error {
+	return t.f.fail(t.JsonWriter.Sync())
+}
+
+func (t *atomicJsonWriter) Commit() error {
+	return t.f.commit(t.JsonWriter.Close())
+}
+
+func (t *atomicJsonWriter) Abort() error {
+	return t.f.abort()
+}
+
+func (t *atomicJsonWriter) Close() error {
+	return t.f.close(t.JsonWriter.Close)
+}
+
+type atomicProtoWriter struct {
+	ProtoWriter
+	f *atomicFile
+}
+
+func NewAtomicProtoFile(filePath string) (AtomicProtoWriter, error) {
+
+	f, err := createAtomicFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicProtoWriter{NewProtoStream(f, strings.HasSuffix(filePath, ".gz")), f}, nil
+}
+
+func (t *atomicProtoWriter) Write(message proto.Message) ([]byte, error) {
+	blob, err := t.ProtoWriter.Write(message)
+	return blob, t.f.fail(err)
+}
+
+func (t *atomicProtoWriter) WriteRaw(blob []byte) error {
+	return t.f.fail(t.ProtoWriter.WriteRaw(blob))
+}
+
+func (t *atomicProtoWriter) Flush() error {
+	return t.f.fail(flushLayers(t.ProtoWriter.Flush, t.f.fw.Flush))
+}
+
+func (t *atomicProtoWriter) Sync() error {
+	return t.f.fail(t.ProtoWriter.Sync())
+}
+
+func (t *atomicProtoWriter) Commit() error {
+	return t.f.commit(t.ProtoWriter.Close())
+}
+
+func (t *atomicProtoWriter) Abort() error {
+	return t.f.abort()
+}
+
+func (t *atomicProtoWriter) Close() error {
+	return t.f.close(t.ProtoWriter.Close)
+}
+
+type atomicRecordWriter struct {
+	RecordWriter
+	f *atomicFile
+}
+
+// CreateAtomicFile creates the records file like CreateFile, Commit or Close renames it into place.
+func CreateAtomicFile(filePath string, header []string) (AtomicRecordWriter, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.CreateAtomicFile(filePath, gzipEnabled, header)
+}
+
+func (t *Format) CreateAtomicFile(filePath string, gzipEnabled bool, header []string) (AtomicRecordWriter, error) {
+
+	f, err := createAtomicFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := t.Create(f, gzipEnabled, header)
+	if err != nil {
+		f.abort()
+		return nil, errors.Errorf("create %s records in '%s', %v", t.Name, filePath, err)
+	}
+
+	return &atomicRecordWriter{writer, f}, nil
+}
+
+func (t *atomicRecordWriter) Write(record Record) error {
+	return t.f.fail(t.RecordWriter.Write(record))
+}
+
+func (t *atomicRecordWriter) Flush() error {
+	return t.f.fail(flushLayers(t.RecordWriter.Flush, t.f.fw.Flush))
+}
+
+func (t *atomicRecordWriter) Commit() error {
+	return t.f.commit(t.RecordWriter.Close())
+}
+
+func (t *atomicRecordWriter) Abort() error {
+	return t.f.abort()
+}
+
+func (t *atomicRecordWriter) Close() error {
+	return t.f.close(t.RecordWriter.Close)
+}
+
+// atomicPart is the destination of a split part or segment that is published when closed.
+type atomicPart struct {
+	*atomicFile
+}
+
+func (t *atomicPart) Flush() error {
+	return t.fail(t.fw.Flush())
+}
+
+func (t *atomicPart) Close() error {
+	return t.commit(nil)
+}
+
+func atomicPartTarget(kind string, partFn func(int) string) partTarget {
+	return func(partNum int) (io.WriteCloser, string, *Format, bool, error) {
+		partFilePath := partFn(partNum)
+		format, gzipEnabled, err := kindFileFormat(kind, partFilePath)
+		if err != nil {
+			return nil, "", nil, false, err
+		}
+		f, err := createAtomicFile(partFilePath)
+		if err != nil {
+			return nil, "", nil, false, err
+		}
+		return &atomicPart{f}, partFilePath, format, gzipEnabled, nil
+	}
+}
+
+// abortPart closes the part, an atomic part is removed instead of being published.
+func abortPart(part *partWriter) {
+	if a, ok := part.wc.(*atomicPart); ok && !part.closed {
+		part.closed = true
+		a.abort()
+		return
+	}
+	part.Close()
+}
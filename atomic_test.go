@@ -0,0 +1,107 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func tempFilesOf(t *testing.T, filePath string) []string {
+	dir, name := filepath.Split(filePath)
+	list, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	var temps []string
+	for _, fi := range list {
+		if strings.HasPrefix(fi.Name(), "."+name+".tmp") {
+			temps = append(temps, fi.Name())
+		}
+	}
+	return temps
+}
+
+func TestAtomicCsvFileCommit(t *testing.T) {
+
+	filePath := tempFilePath(t, "atomic-test") + ".csv.gz"
+	defer os.Remove(filePath)
+
+	writer, err := files.NewAtomicCsvFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write("name", "count"))
+	require.NoError(t, writer.Write("a", "1"))
+
+	_, err = os.Stat(filePath)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 1, len(tempFilesOf(t, filePath)))
+
+	require.NoError(t, writer.Commit())
+	require.NoError(t, writer.Close())
+	require.Equal(t, 0, len(tempFilesOf(t, filePath)))
+
+	reader, err := files.OpenFile(filePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.Equal(t, []string{"name", "count"}, reader.Header())
+	record, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "1"}, record.Values)
+}
+
+func TestAtomicFileAbort(t *testing.T) {
+
+	filePath := tempFilePath(t, "atomic-test") + ".jsonl"
+	defer os.Remove(filePath)
+	require.NoError(t, ioutil.WriteFile(filePath, []byte("{\"a\":1}\n"), 0644))
+
+	writer, err := files.NewAtomicJsonFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(map[string]int{"a": 2}))
+	require.NoError(t, writer.Abort())
+	require.NoError(t, writer.Close())
+
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "{\"a\":1}\n", string(content))
+	require.Equal(t, 0, len(tempFilesOf(t, filePath)))
+}
+
+func TestAtomicFileCloseAfterWriteError(t *testing.T) {
+
+	filePath := tempFilePath(t, "atomic-test") + ".csv"
+	defer os.Remove(filePath)
+
+	writer, err := files.CreateAtomicFile(filePath, []string{"name"})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(files.Record{Values: []string{"a"}}))
+	require.Error(t, writer.Write(files.Record{Raw: []byte("{}")}))
+	require.Error(t, writer.Close())
+
+	_, err = os.Stat(filePath)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 0, len(tempFilesOf(t, filePath)))
+}
+
+func TestAtomicProtoFileClose(t *testing.T) {
+
+	filePath := tempFilePath(t, "atomic-test") + ".pb"
+	defer os.Remove(filePath)
+
+	writer, err := files.NewAtomicProtoFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteRaw([]byte{1, 2, 3}))
+	require.NoError(t, writer.Close())
+
+	reader, err := files.OpenProtoFile(filePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	blob, err := reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, blob)
+}
@@ -0,0 +1,120 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"reflect"
+	"strings"
+)
+
+// CloseError is returned by Close when more than one writer layer failed, Err is the first failure.
+type CloseError struct {
+	Err    error
+	Others []error
+}
+
+func (e *CloseError) Error() string {
+	var out strings.Builder
+	out.WriteString(e.Err.Error())
+	for _, err := range e.Others {
+		out.WriteString("; ")
+		out.WriteString(err.Error())
+	}
+	return out.String()
+}
+
+func (e *CloseError) Cause() error {
+	return e.Err
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+// closeLayers runs the close steps of writer layers from the top, like csv, bufio, gzip and file,
+// all steps run after a failure to release the file. Sticky errors repeated by lower layers are reported once.
+func closeLayers(steps ...func() error) error {
+	var first error
+	var others []error
+	for _, step := range steps {
+		err := step()
+		if err == nil || sameError(err, first) || containsError(others, err) {
+			continue
+		}
+		if first == nil {
+			first = err
+		} else {
+			others = append(others, err)
+		}
+	}
+	if len(others) > 0 {
+		return &CloseError{first, others}
+	}
+	return first
+}
+
+// sameError compares errors without panics on error types that are not comparable.
+func sameError(a, b error) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func containsError(list []error, err error) bool {
+	for _, e := range list {
+		if sameError(e, err) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushLayers runs the flush steps of writer layers from the top and stops on the first failure.
+func flushLayers(steps ...func() error) error {
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvFlush is the flush step of the csv layer, csv.Writer keeps the write errors until Error is called.
+func csvFlush(w *csv.Writer) func() error {
+	return func() error {
+		w.Flush()
+		return w.Error()
+	}
+}
+
+// bufferFlush, gzipFlush and gzipClose are the steps of optional layers.
+func bufferFlush(w *bufio.Writer) func() error {
+	if w == nil {
+		return noStep
+	}
+	return w.Flush
+}
+
+func gzipFlush(w *gzip.Writer) func() error {
+	if w == nil {
+		return noStep
+	}
+	return w.Flush
+}
+
+func gzipClose(w *gzip.Writer) func() error {
+	if w == nil {
+		return noStep
+	}
+	return w.Close
+}
+
+func noStep() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"os"
+	"testing"
+)
+
+// fullDevice links a file name with the extension to /dev/full, writes to it fail with ENOSPC.
+func fullDevice(t *testing.T, ext string) string {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	filePath := tempFilePath(t, "close-test") + ext
+	require.NoError(t, os.Symlink("/dev/full", filePath))
+	return filePath
+}
+
+func TestCloseReportsDiskFull(t *testing.T) {
+
+	for _, ext := range []string{".csv", ".csv.gz", ".jsonl", ".jsonl.gz", ".pb", ".pb.gz"} {
+
+		filePath := fullDevice(t, ext)
+		defer os.Remove(filePath)
+
+		var err error
+		switch ext {
+		case ".csv", ".csv.gz":
+			var writer files.CsvWriter
+			writer, err = files.NewCsvFile(filePath)
+			require.NoError(t, err)
+			require.NoError(t, writer.Write("a", "b"))
+			require.Error(t, writer.Flush(), ext)
+			err = writer.Close()
+		case ".jsonl", ".jsonl.gz":
+			var writer files.JsonWriter
+			writer, err = files.NewJsonFile(filePath)
+			require.NoError(t, err)
+			require.NoError(t, writer.WriteRaw([]byte("{}")))
+			require.Error(t, writer.Flush(), ext)
+			err = writer.Close()
+		default:
+			var writer files.ProtoWriter
+			writer, err = files.NewProtoFile(filePath)
+			require.NoError(t, err)
+			require.NoError(t, writer.WriteRaw([]byte{1}))
+			require.Error(t, writer.Flush(), ext)
+			err = writer.Close()
+		}
+		require.Error(t, err, ext)
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (t *failingWriter) Write(p []byte) (int, error) {
+	return 0, t.err
+}
+
+func TestStreamCloseReportsWriteError(t *testing.T) {
+
+	failure := errors.New("write failed")
+
+	csvWriter := files.NewCsvStream(&failingWriter{failure}, true)
+	require.NoError(t, csvWriter.Write("a"))
+	require.Equal(t, failure, csvWriter.Close())
+
+	jsonWriter := files.NewJsonStream(&failingWriter{failure}, false)
+	require.NoError(t, jsonWriter.WriteRaw([]byte("{}")))
+	require.Equal(t, failure, jsonWriter.Close())
+
+	protoWriter := files.NewProtoStream(&failingWriter{failure}, true)
+	require.NoError(t, protoWriter.WriteRaw([]byte{1}))
+	require.Equal(t, failure, protoWriter.Close())
+}
+
+func TestCloseError(t *testing.T) {
+	first, second := errors.New("first"), errors.New("second")
+	err := &files.CloseError{Err: first, Others: []error{second}}
+	require.Equal(t, "first; second", err.Error())
+	require.True(t, errors.Is(err, first))
+}
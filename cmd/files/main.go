@@ -0,0 +1,111 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go.arpabet.com/files"
+	"os"
+	"strings"
+)
+
+type columnsFlag map[string]string
+
+func (t columnsFlag) String() string {
+	var list []string
+	for column, field := range t {
+		list = append(list, column+"="+field)
+	}
+	return strings.Join(list, ",")
+}
+
+func (t columnsFlag) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return fmt.Errorf("expected column=field, got '%s'", value)
+	}
+	t[value[:i]] = value[i+1:]
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: files convert [options] input output\n\n")
+	fmt.Fprintf(os.Stderr, "Formats are selected by extension: .csv, .json, .jsonl, .ndjson, .json-seq, .pb, with optional .gz\n\n")
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "convert":
+		os.Exit(convert(os.Args[2:]))
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func convert(args []string) int {
+
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+
+	descriptorSet := fs.String("descriptor", "", "FileDescriptorSet file produced by protoc --include_imports --descriptor_set_out")
+	messageName := fs.String("message", "", "full name of the record message")
+	skipErrors := fs.Bool("skip-errors", false, "skip records that can not be converted")
+	ignoreUnknown := fs.Bool("ignore-unknown", false, "ignore csv columns that do not map to a field")
+	inferTypes := fs.Bool("infer-types", false, "write numbers and booleans from csv cells as json literals when no message is given")
+	columns := make(columnsFlag)
+	fs.Var(columns, "column", "csv column to field mapping as column=field, repeatable")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	opts := files.ConvertOptions{
+		Columns:          columns,
+		IgnoreUnknown:    *ignoreUnknown,
+		MarshalOptions:   files.Marshaler.MarshalOptions,
+		UnmarshalOptions: files.Marshaler.UnmarshalOptions,
+		Flatten:          files.FlattenOptions{InferTypes: *inferTypes},
+	}
+
+	if *descriptorSet != "" || *messageName != "" {
+		md, err := files.LoadMessageDescriptor(*descriptorSet, *messageName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		opts.Message = md
+	}
+
+	if *skipErrors {
+		opts.OnError = func(record int, err error) error {
+			fmt.Fprintf(os.Stderr, "record %d: %v\n", record, err)
+			return nil
+		}
+	}
+
+	report, err := files.ConvertFile(fs.Arg(0), fs.Arg(1), opts)
+	if report != nil {
+		fmt.Fprintf(os.Stderr, "converted %d records, skipped %d\n", report.Records, report.Errors)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
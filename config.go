@@ -7,10 +7,17 @@ package files
 import (
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/protobuf/encoding/protojson"
+	"time"
 )
 
 var FileRWBlockSize = 1024 * 64  // 64kb
 
+var ProgressInterval = time.Second  // min interval between progress reports
+
+var SyncDir = false  // fsync the parent directory after files are created or renamed into place
+
+var SyncOnClose = false  // fsync files before they are closed
+
 var Marshaler = &runtime.JSONPb {
 	MarshalOptions: protojson.MarshalOptions{
 		UseProtoNames:     true,
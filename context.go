@@ -0,0 +1,276 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"io"
+)
+
+func contextErr(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// ContextReader returns ctx.Err() from Read once the context is done, like for OpenCsvStream or JsonStream.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx, r}
+}
+
+func (t *contextReader) Read(p []byte) (int, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return 0, err
+	}
+	return t.r.Read(p)
+}
+
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// ContextWriter returns ctx.Err() from Write once the context is done, like for NewCsvStream or NewProtoStream.
+func ContextWriter(ctx context.Context, w io.Writer) io.Writer {
+	return &contextWriter{ctx, w}
+}
+
+func (t *contextWriter) Write(p []byte) (int, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
+
+type contextRecordReader struct {
+	RecordReader
+	ctx context.Context
+}
+
+// WithContextReader binds the context to the reader, Read returns ctx.Err() once the context is done.
+func WithContextReader(ctx context.Context, reader RecordReader) RecordReader {
+	return &contextRecordReader{reader, ctx}
+}
+
+func (t *contextRecordReader) Read() (Record, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return Record{}, err
+	}
+	return t.RecordReader.Read()
+}
+
+type contextRecordWriter struct {
+	RecordWriter
+	ctx context.Context
+}
+
+// WithContextWriter binds the context to the writer, Write and Flush return ctx.Err() once the context is done.
+func WithContextWriter(ctx context.Context, writer RecordWriter) RecordWriter {
+	return &contextRecordWriter{writer, ctx}
+}
+
+func (t *contextRecordWriter) Write(record Record) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.RecordWriter.Write(record)
+}
+
+func (t *contextRecordWriter) Flush() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.RecordWriter.Flush()
+}
+
+type contextCsvReader struct {
+	CsvReader
+	ctx context.Context
+}
+
+// WithContextCsvReader binds the context to the reader, Read returns ctx.Err() once the context is done.
+func WithContextCsvReader(ctx context.Context, reader CsvReader) CsvReader {
+	return &contextCsvReader{reader, ctx}
+}
+
+func (t *contextCsvReader) ReadHeader() (CsvFile, error) {
+	header, err := t.Read()
+	if err != nil {
+		return nil, err
+	}
+	return newCsvFile(header, t), nil
+}
+
+func (t *contextCsvReader) Read() ([]string, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return nil, err
+	}
+	return t.CsvReader.Read()
+}
+
+type contextCsvWriter struct {
+	CsvWriter
+	ctx context.Context
+}
+
+// WithContextCsvWriter binds the context to the writer, Write, Flush and Sync return ctx.Err() once the context is done.
+func WithContextCsvWriter(ctx context.Context, writer CsvWriter) CsvWriter {
+	return &contextCsvWriter{writer, ctx}
+}
+
+func (t *contextCsvWriter) Write(values ...string) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.CsvWriter.Write(values...)
+}
+
+func (t *contextCsvWriter) Flush() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.CsvWriter.Flush()
+}
+
+func (t *contextCsvWriter) Sync() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.CsvWriter.Sync()
+}
+
+type contextJsonReader struct {
+	JsonReader
+	ctx context.Context
+}
+
+// WithContextJsonReader binds the context to the reader, ReadRaw and Read return ctx.Err() once the context is done.
+func WithContextJsonReader(ctx context.Context, reader JsonReader) JsonReader {
+	return &contextJsonReader{reader, ctx}
+}
+
+func (t *contextJsonReader) ReadRaw() (json.RawMessage, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return nil, err
+	}
+	return t.JsonReader.ReadRaw()
+}
+
+func (t *contextJsonReader) Read(holder interface{}) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.JsonReader.Read(holder)
+}
+
+type contextJsonWriter struct {
+	JsonWriter
+	ctx context.Context
+}
+
+// WithContextJsonWriter binds the context to the writer, writes, Flush and Sync return ctx.Err() once the context is done.
+func WithContextJsonWriter(ctx context.Context, writer JsonWriter) JsonWriter {
+	return &contextJsonWriter{writer, ctx}
+}
+
+func (t *contextJsonWriter) WriteRaw(message json.RawMessage) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.JsonWriter.WriteRaw(message)
+}
+
+func (t *contextJsonWriter) Write(object interface{}) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.JsonWriter.Write(object)
+}
+
+func (t *contextJsonWriter) Flush() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.JsonWriter.Flush()
+}
+
+func (t *contextJsonWriter) Sync() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.JsonWriter.Sync()
+}
+
+type contextProtoReader struct {
+	ProtoReader
+	ctx context.Context
+}
+
+// WithContextProtoReader binds the context to the reader, ReadTo and ReadRaw return ctx.Err() once the context is done.
+func WithContextProtoReader(ctx context.Context, reader ProtoReader) ProtoReader {
+	return &contextProtoReader{reader, ctx}
+}
+
+func (t *contextProtoReader) ReadTo(message proto.Message) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.ProtoReader.ReadTo(message)
+}
+
+func (t *contextProtoReader) ReadRaw() ([]byte, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return nil, err
+	}
+	return t.ProtoReader.ReadRaw()
+}
+
+type contextProtoWriter struct {
+	ProtoWriter
+	ctx context.Context
+}
+
+// WithContextProtoWriter binds the context to the writer, writes, Flush and Sync return ctx.Err() once the context is done.
+func WithContextProtoWriter(ctx context.Context, writer ProtoWriter) ProtoWriter {
+	return &contextProtoWriter{writer, ctx}
+}
+
+func (t *contextProtoWriter) Write(message proto.Message) ([]byte, error) {
+	if err := contextErr(t.ctx); err != nil {
+		return nil, err
+	}
+	return t.ProtoWriter.Write(message)
+}
+
+func (t *contextProtoWriter) WriteRaw(blob []byte) error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.ProtoWriter.WriteRaw(blob)
+}
+
+func (t *contextProtoWriter) Flush() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.ProtoWriter.Flush()
+}
+
+func (t *contextProtoWriter) Sync() error {
+	if err := contextErr(t.ctx); err != nil {
+		return err
+	}
+	return t.ProtoWriter.Sync()
+}
@@ -0,0 +1,339 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSplitFileContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	csvFilePath := filePath + ".csv"
+	defer os.Remove(csvFilePath)
+
+	writer, err := files.CreateFile(csvFilePath, []string{"name", "count"})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var created []string
+	_, err = files.SplitFileContext(ctx, csvFilePath, files.SplitOptions{Limit: 10}, func(i int) string {
+		if i == 3 {
+			cancel()
+		}
+		partPath := fmt.Sprintf("%s_part%d.csv", filePath, i)
+		created = append(created, partPath)
+		return partPath
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 3, len(created))
+
+	for _, partPath := range created {
+		_, err := os.Stat(partPath)
+		require.True(t, os.IsNotExist(err), partPath)
+	}
+}
+
+func TestJoinFilesContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	partPath := filePath + "_part1.csv"
+	outPath := filePath + ".csv"
+	defer os.Remove(partPath)
+
+	writer, err := files.CreateFile(partPath, []string{"name"})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(files.Record{Values: []string{"a"}}))
+	require.NoError(t, writer.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := files.JoinFilesContext(ctx, outPath, []string{partPath}, files.JoinOptions{})
+	require.Equal(t, context.Canceled, err)
+	require.Nil(t, report)
+
+	_, err = os.Stat(outPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestContextReaderAndWriter(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var buf bytes.Buffer
+	w := files.ContextWriter(ctx, &buf)
+	_, err := w.Write([]byte("name\na\n"))
+	require.NoError(t, err)
+
+	reader, err := files.CsvFormat.Open(files.ContextReader(ctx, bytes.NewReader(buf.Bytes())), false)
+	require.NoError(t, err)
+	reader = files.WithContextReader(ctx, reader)
+
+	record, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, record.Values)
+
+	cancel()
+
+	_, err = reader.Read()
+	require.Equal(t, context.Canceled, err)
+
+	_, err = w.Write([]byte("b\n"))
+	require.Equal(t, context.Canceled, err)
+
+	recordWriter, err := files.CsvFormat.Create(ioutil.Discard, false, []string{"name"})
+	require.NoError(t, err)
+	recordWriter = files.WithContextWriter(ctx, recordWriter)
+	require.Equal(t, context.Canceled, recordWriter.Write(files.Record{Values: []string{"b"}}))
+}
+
+// cancelingKey takes the key from the column and cancels the context on the extraction number after.
+func cancelingKey(column string, after int, cancel context.CancelFunc) files.RecordKey {
+	var count int
+	return func(header []string) (files.KeyExtractor, error) {
+		extractor, err := files.CsvColumnKey(column)(header)
+		if err != nil {
+			return nil, err
+		}
+		return func(record files.Record) (string, error) {
+			if count++; count == after {
+				cancel()
+			}
+			return extractor(record)
+		}, nil
+	}
+}
+
+func writeNumberedCsv(t *testing.T, filePath string, rows int) {
+	writer, err := files.CreateFile(filePath, []string{"name", "count"})
+	require.NoError(t, err)
+	for i := 0; i < rows; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", rows-i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Close())
+}
+
+func TestSortFileContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	inputFilePath := filePath + ".csv"
+	outputFilePath := filePath + "_sorted.csv"
+	defer os.Remove(inputFilePath)
+	defer os.Remove(outputFilePath)
+
+	writeNumberedCsv(t, inputFilePath, 1000)
+
+	for _, memoryBytes := range []int64{0, 1000} {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := files.SortCsvFileContext(ctx, inputFilePath, outputFilePath, files.SortOptions{
+			Order:       files.OrderByKey(cancelingKey("name", 100, cancel)),
+			MemoryBytes: memoryBytes,
+		})
+		cancel()
+		require.Equal(t, context.Canceled, err)
+		_, err = os.Stat(outputFilePath)
+		require.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestJoinSortedFilesContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	outPath := filePath + ".csv"
+	var parts []string
+	for i := 1; i <= 2; i++ {
+		partPath := fmt.Sprintf("%s_part%d.csv", filePath, i)
+		defer os.Remove(partPath)
+		var rows [][]string
+		for j := 0; j < 100; j++ {
+			rows = append(rows, []string{fmt.Sprintf("name%03d", j)})
+		}
+		writeCsvPart(t, partPath, []string{"name"}, rows...)
+		parts = append(parts, partPath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	report, err := files.JoinSortedFilesContext(ctx, outPath, parts, files.MergeOptions{
+		Order: files.OrderByKey(cancelingKey("name", 50, cancel)),
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Nil(t, report)
+
+	_, err = os.Stat(outPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSplitFileByKeyContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	inputFilePath := filePath + ".csv"
+	defer os.Remove(inputFilePath)
+
+	writeNumberedCsv(t, inputFilePath, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var created []string
+	parts, err := files.SplitFileByKeyContext(ctx, inputFilePath, cancelingKey("name", 100, cancel), 4, func(i int) string {
+		partPath := fmt.Sprintf("%s_part%d.csv", filePath, i)
+		created = append(created, partPath)
+		return partPath
+	})
+	require.Equal(t, context.Canceled, err)
+	require.Nil(t, parts)
+
+	for _, partPath := range created {
+		_, err := os.Stat(partPath)
+		require.True(t, os.IsNotExist(err), partPath)
+	}
+}
+
+func TestConvertFileContextCancel(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	csvFilePath := filePath + ".csv"
+	jsonFilePath := filePath + ".jsonl"
+	csvResultPath := filePath + "_result.csv"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(csvResultPath)
+
+	writeNumberedCsv(t, csvFilePath, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := files.ConvertFileContext(ctx, csvFilePath, jsonFilePath, files.ConvertOptions{})
+	require.Equal(t, context.Canceled, err)
+	_, err = os.Stat(jsonFilePath)
+	require.True(t, os.IsNotExist(err))
+
+	report, err := files.ConvertFile(csvFilePath, jsonFilePath, files.ConvertOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 100, report.Records)
+
+	_, err = files.ConvertFileContext(ctx, jsonFilePath, csvResultPath, files.ConvertOptions{})
+	require.Equal(t, context.Canceled, err)
+	_, err = os.Stat(csvResultPath)
+	require.True(t, os.IsNotExist(err))
+
+	// the message path stops at the next record once OnError cancels
+	err = ioutil.WriteFile(csvFilePath, []byte("domain,options\nobj1,\nobj2,[1,x\nobj3,\nobj4,\n"), 0644)
+	require.NoError(t, err)
+
+	protoFilePath := filePath + ".pb"
+	defer os.Remove(protoFilePath)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = files.ConvertFileContext(ctx, csvFilePath, protoFilePath, files.ConvertOptions{
+		Message: proto.MessageV2(&Domain{}).ProtoReflect().Descriptor(),
+		OnError: func(record int, err error) error {
+			cancel()
+			return nil
+		},
+	})
+	require.Equal(t, context.Canceled, err)
+	_, err = os.Stat(protoFilePath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestContextTypedReadersAndWriters(t *testing.T) {
+
+	filePath := tempFilePath(t, "context-test")
+	csvFilePath := filePath + ".csv"
+	jsonFilePath := filePath + ".jsonl"
+	protoFilePath := filePath + ".pb"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(protoFilePath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	csvFile, err := files.NewCsvFile(csvFilePath)
+	require.NoError(t, err)
+	csvWriter := files.WithContextCsvWriter(ctx, csvFile)
+	require.NoError(t, csvWriter.Write("name"))
+	require.NoError(t, csvWriter.Write("a"))
+	require.NoError(t, csvWriter.Write("b"))
+
+	jsonFile, err := files.NewJsonFile(jsonFilePath)
+	require.NoError(t, err)
+	jsonWriter := files.WithContextJsonWriter(ctx, jsonFile)
+	require.NoError(t, jsonWriter.WriteRaw([]byte(`{"name":"a"}`)))
+	require.NoError(t, jsonWriter.WriteRaw([]byte(`{"name":"b"}`)))
+
+	protoFile, err := files.NewProtoFile(protoFilePath)
+	require.NoError(t, err)
+	protoWriter := files.WithContextProtoWriter(ctx, protoFile)
+	require.NoError(t, protoWriter.WriteRaw([]byte("a")))
+	require.NoError(t, protoWriter.WriteRaw([]byte("b")))
+
+	cancel()
+
+	require.Equal(t, context.Canceled, csvWriter.Write("c"))
+	require.Equal(t, context.Canceled, jsonWriter.WriteRaw([]byte(`{"name":"c"}`)))
+	require.Equal(t, context.Canceled, protoWriter.WriteRaw([]byte("c")))
+	require.NoError(t, csvWriter.Close())
+	require.NoError(t, jsonWriter.Close())
+	require.NoError(t, protoWriter.Close())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	csvReader, err := files.OpenCsvFile(csvFilePath)
+	require.NoError(t, err)
+	defer csvReader.Close()
+	csvTable, err := files.WithContextCsvReader(ctx, csvReader).ReadHeader()
+	require.NoError(t, err)
+	csvRecord, err := csvTable.Next()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, csvRecord.Record())
+
+	jsonReader, err := files.OpenJsonFile(jsonFilePath)
+	require.NoError(t, err)
+	defer jsonReader.Close()
+	jsonCtxReader := files.WithContextJsonReader(ctx, jsonReader)
+	raw, err := jsonCtxReader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, `{"name":"a"}`, string(raw))
+
+	protoReader, err := files.OpenProtoFile(protoFilePath)
+	require.NoError(t, err)
+	defer protoReader.Close()
+	protoCtxReader := files.WithContextProtoReader(ctx, protoReader)
+	raw, err = protoCtxReader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(raw))
+
+	cancel()
+
+	_, err = csvTable.Next()
+	require.Equal(t, context.Canceled, err)
+	_, err = jsonCtxReader.ReadRaw()
+	require.Equal(t, context.Canceled, err)
+	_, err = protoCtxReader.ReadRaw()
+	require.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,697 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	CsvRecords   = "csv"
+	JsonRecords  = "json"
+	ProtoRecords = "proto"
+)
+
+// RecordsOf returns the kind of records stored in the file by the registered format of its extension.
+func RecordsOf(filePath string) (string, error) {
+	format, _, err := FormatOf(filePath)
+	if err != nil {
+		return "", err
+	}
+	return format.Kind, nil
+}
+
+// LoadMessageDescriptor finds the message in a FileDescriptorSet produced by "protoc --include_imports --descriptor_set_out".
+func LoadMessageDescriptor(descriptorSetPath, messageName string) (protoreflect.MessageDescriptor, error) {
+
+	content, err := ioutil.ReadFile(descriptorSetPath)
+	if err != nil {
+		return nil, errors.Errorf("file read error '%s', %v", descriptorSetPath, err)
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(content, &set); err != nil {
+		return nil, errors.Errorf("descriptor set unmarshal error '%s', %v", descriptorSetPath, err)
+	}
+
+	registry, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, errors.Errorf("descriptor set error '%s', %v", descriptorSetPath, err)
+	}
+
+	desc, err := registry.FindDescriptorByName(protoreflect.FullName(messageName))
+	if err != nil {
+		return nil, errors.Errorf("message '%s' not found in '%s', %v", messageName, descriptorSetPath, err)
+	}
+
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.Errorf("'%s' is not a message in '%s'", messageName, descriptorSetPath)
+	}
+	return md, nil
+}
+
+type ConvertOptions struct {
+	Message          protoreflect.MessageDescriptor    // message type of the records, optional for csv to json and back
+	Columns          map[string]string                 // csv column name to proto field name overrides
+	IgnoreUnknown    bool                              // skip csv columns that do not map to a field
+	OnError          func(record int, err error) error // receives per-record conversion errors, nil return skips the record, nil handler fails
+	MarshalOptions   protojson.MarshalOptions
+	UnmarshalOptions protojson.UnmarshalOptions
+	Flatten          FlattenOptions   // used for csv to json and back when Message is nil
+	Progress         ProgressObserver // reports reading the input, a flatten with negative SampleSize reads it twice, optional
+}
+
+type ConvertReport struct {
+	Records int // records written
+	Errors  int // records skipped by OnError
+}
+
+// ConvertFile converts records between csv, json and length-delimited proto files selected by extension,
+// compression on either side is enabled by the ".gz" suffix.
+func ConvertFile(inputFilePath, outputFilePath string, opts ConvertOptions) (*ConvertReport, error) {
+	return ConvertFileContext(context.Background(), inputFilePath, outputFilePath, opts)
+}
+
+// ConvertFileContext converts like ConvertFile and stops with ctx.Err() once the context is done, the output is removed.
+func ConvertFileContext(ctx context.Context, inputFilePath, outputFilePath string, opts ConvertOptions) (*ConvertReport, error) {
+
+	from, err := RecordsOf(inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	to, err := RecordsOf(outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(ConvertReport)
+	tracker := newFilesProgressTracker(opts.Progress, []string{inputFilePath})
+
+	if opts.Message == nil {
+		if from == CsvRecords && to == JsonRecords {
+			err = convertCsvToJson(ctx, inputFilePath, outputFilePath, opts, tracker, report)
+		} else if from == JsonRecords && to == CsvRecords {
+			if tracker != nil && opts.Flatten.SampleSize < 0 {
+				tracker.progress.TotalBytes *= 2 // the columns are discovered by an extra pass
+			}
+			err = convertJsonToCsv(ctx, inputFilePath, outputFilePath, opts, tracker, report)
+		} else {
+			err = errors.Errorf("message descriptor is required to convert %s to %s", from, to)
+		}
+		if err == nil && tracker != nil {
+			tracker.finish()
+		}
+		return report, err
+	}
+
+	src, err := openMessageSource(inputFilePath, from, opts, tracker)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dst, err := newMessageSink(outputFilePath, to, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; ; n++ {
+		if err = contextErr(ctx); err != nil {
+			break
+		}
+		msg := dynamicpb.NewMessage(opts.Message)
+		err = src.read(msg)
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err == nil {
+			err = dst.write(msg)
+			if err != nil {
+				err = errors.Errorf("write record %d to '%s', %v", n, outputFilePath, err)
+				break
+			}
+			report.Records++
+			continue
+		}
+		if _, fatal := err.(fatalConvertError); fatal || opts.OnError == nil {
+			break
+		}
+		if err = opts.OnError(n, err); err != nil {
+			break
+		}
+		report.Errors++
+	}
+
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+	} else if tracker != nil {
+		tracker.finish()
+	}
+	return report, err
+}
+
+// fatalConvertError stops the conversion regardless of OnError, the input can not be read any further.
+type fatalConvertError struct {
+	error
+}
+
+type messageSource interface {
+	read(msg *dynamicpb.Message) error
+	Close() error
+}
+
+type messageSink interface {
+	write(msg *dynamicpb.Message) error
+	Close() error
+}
+
+func openMessageSource(filePath, records string, opts ConvertOptions, tracker *progressTracker) (messageSource, error) {
+	switch records {
+	case CsvRecords:
+		return openCsvMessageSource(filePath, opts, tracker)
+	case JsonRecords:
+		r, err := openJsonInput(filePath, tracker)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonMessageSource{r, opts.UnmarshalOptions}, nil
+	default:
+		r, err := openProtoInput(filePath, tracker)
+		if err != nil {
+			return nil, err
+		}
+		return &protoMessageSource{r}, nil
+	}
+}
+
+func newMessageSink(filePath, records string, opts ConvertOptions) (messageSink, error) {
+	switch records {
+	case CsvRecords:
+		return newCsvMessageSink(filePath, opts)
+	case JsonRecords:
+		w, err := NewJsonFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonMessageSink{w, opts.MarshalOptions}, nil
+	default:
+		w, err := NewProtoFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return &protoMessageSink{w}, nil
+	}
+}
+
+type jsonMessageSource struct {
+	r    JsonReader
+	opts protojson.UnmarshalOptions
+}
+
+func (t *jsonMessageSource) read(msg *dynamicpb.Message) error {
+	jsonBin, err := t.r.ReadRaw()
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fatalConvertError{err}
+	}
+	return t.opts.Unmarshal(jsonBin, msg)
+}
+
+func (t *jsonMessageSource) Close() error {
+	return t.r.Close()
+}
+
+type jsonMessageSink struct {
+	w    JsonWriter
+	opts protojson.MarshalOptions
+}
+
+func (t *jsonMessageSink) write(msg *dynamicpb.Message) error {
+	jsonBin, err := t.opts.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return t.w.WriteRaw(compactJson(jsonBin))
+}
+
+func (t *jsonMessageSink) Close() error {
+	return t.w.Close()
+}
+
+func compactJson(jsonBin []byte) []byte {
+	var buf bytes.Buffer
+	if json.Compact(&buf, jsonBin) != nil {
+		return jsonBin
+	}
+	return buf.Bytes()
+}
+
+type protoMessageSource struct {
+	r ProtoReader
+}
+
+func (t *protoMessageSource) read(msg *dynamicpb.Message) error {
+	err := t.r.ReadTo(msg)
+	if err != nil && err != io.EOF {
+		return fatalConvertError{err}
+	}
+	return err
+}
+
+func (t *protoMessageSource) Close() error {
+	return t.r.Close()
+}
+
+type protoMessageSink struct {
+	w ProtoWriter
+}
+
+func (t *protoMessageSink) write(msg *dynamicpb.Message) error {
+	_, err := t.w.Write(msg)
+	return err
+}
+
+func (t *protoMessageSink) Close() error {
+	return t.w.Close()
+}
+
+type csvMessageSource struct {
+	r      CsvReader
+	fields []protoreflect.FieldDescriptor // by column, nil for ignored columns
+	opts   protojson.UnmarshalOptions
+}
+
+func openCsvMessageSource(filePath string, opts ConvertOptions, tracker *progressTracker) (*csvMessageSource, error) {
+
+	r, err := openCsvInput(filePath, tracker)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := r.ReadHeader()
+	if err != nil {
+		r.Close()
+		return nil, errors.Errorf("can not read header in file '%s', %v", filePath, err)
+	}
+	header := file.Header()
+
+	t := &csvMessageSource{
+		r:      r,
+		fields: make([]protoreflect.FieldDescriptor, len(header)),
+		opts:   opts.UnmarshalOptions,
+	}
+
+	fields := opts.Message.Fields()
+	for i, column := range header {
+		name := column
+		if override, ok := opts.Columns[column]; ok {
+			name = override
+		}
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil && !opts.IgnoreUnknown {
+			r.Close()
+			return nil, errors.Errorf("column '%s' in file '%s' does not map to a field of '%s'", column, filePath, opts.Message.FullName())
+		}
+		t.fields[i] = fd
+	}
+
+	return t, nil
+}
+
+// read builds a protojson object of the row, so well-known types, enums and nested messages in JSON cells are parsed by protojson.
+func (t *csvMessageSource) read(msg *dynamicpb.Message) error {
+
+	row, err := t.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			return err
+		}
+		return fatalConvertError{err}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for i, value := range row {
+		if i >= len(t.fields) || t.fields[i] == nil || value == "" {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(string(t.fields[i].Name()))
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(csvCellJson(t.fields[i], value))
+	}
+	buf.WriteByte('}')
+
+	return t.opts.Unmarshal(buf.Bytes(), msg)
+}
+
+func csvCellJson(fd protoreflect.FieldDescriptor, value string) []byte {
+	literal := false
+	if fd.IsList() || fd.IsMap() {
+		literal = true
+	} else {
+		switch fd.Kind() {
+		case protoreflect.BoolKind, protoreflect.FloatKind, protoreflect.DoubleKind,
+			protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.EnumKind:
+			literal = json.Valid([]byte(value))
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			literal = strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")
+		}
+	}
+	if literal {
+		return []byte(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return quoted
+}
+
+func (t *csvMessageSource) Close() error {
+	return t.r.Close()
+}
+
+type csvMessageSink struct {
+	w        CsvWriter
+	pointers []string
+	opts     protojson.MarshalOptions
+}
+
+func newCsvMessageSink(filePath string, opts ConvertOptions) (*csvMessageSink, error) {
+
+	w, err := createCsvFile(filePath, csvCommaOf(filePath), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]string)
+	for column, field := range opts.Columns {
+		columns[field] = column
+	}
+
+	fields := opts.Message.Fields()
+	header := make([]string, fields.Len())
+	t := &csvMessageSink{
+		w:        w,
+		pointers: make([]string, fields.Len()),
+		opts:     opts.MarshalOptions,
+	}
+	t.opts.UseProtoNames = true
+	t.opts.Multiline = false
+
+	for i := 0; i < fields.Len(); i++ {
+		name := string(fields.Get(i).Name())
+		t.pointers[i] = "/" + escapeJsonPointer(name)
+		if column, ok := columns[name]; ok {
+			name = column
+		}
+		header[i] = name
+	}
+
+	if err := w.Write(header...); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *csvMessageSink) write(msg *dynamicpb.Message) error {
+	jsonBin, err := t.opts.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// protojson randomly adds whitespace, compact it to keep cells stable
+	values, err := ExtractJsonPointers(compactJson(jsonBin), t.pointers...)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(t.pointers))
+	for i, pointer := range t.pointers {
+		row[i] = JsonValueString(values[pointer])
+	}
+	return t.w.Write(row...)
+}
+
+func (t *csvMessageSink) Close() error {
+	return t.w.Close()
+}
+
+func convertCsvToJson(ctx context.Context, inputFilePath, outputFilePath string, opts ConvertOptions, tracker *progressTracker, report *ConvertReport) error {
+
+	reader, err := openCsvInput(inputFilePath, tracker)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := reader.ReadHeader()
+	if err != nil {
+		return errors.Errorf("can not read header in file '%s', %v", inputFilePath, err)
+	}
+
+	writer, err := NewJsonFile(outputFilePath)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if err = contextErr(ctx); err != nil {
+			break
+		}
+		var record CsvRecord
+		record, err = file.Next()
+		if err != nil {
+			break
+		}
+		err = writer.WriteRaw(UnflattenCsv(file.Header(), record.Record(), opts.Flatten))
+		if err != nil {
+			break
+		}
+		report.Records++
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+	}
+	return err
+}
+
+func convertJsonToCsv(ctx context.Context, inputFilePath, outputFilePath string, opts ConvertOptions, tracker *progressTracker, report *ConvertReport) error {
+
+	writer, err := createCsvFile(outputFilePath, csvCommaOf(outputFilePath), nil)
+	if err != nil {
+		return err
+	}
+
+	counter := &csvRowCounter{w: writer, rows: -1} // header
+	err = flattenJsonFile(ctx, inputFilePath, tracker, counter, opts.Flatten)
+	if counter.rows > 0 {
+		report.Records = counter.rows
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+	}
+	return err
+}
+
+type csvRowCounter struct {
+	w    CsvWriter
+	rows int
+}
+
+func (t *csvRowCounter) Write(values ...string) error {
+	err := t.w.Write(values...)
+	if err == nil {
+		t.rows++
+	}
+	return err
+}
+
+func (t *csvRowCounter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *csvRowCounter) Sync() error {
+	return t.w.Sync()
+}
+
+func (t *csvRowCounter) Close() error {
+	return t.w.Close()
+}
+
+// openCsvInput opens the csv or tsv input of the conversion, with the tracker the bytes and records read are counted.
+func openCsvInput(filePath string, tracker *progressTracker) (CsvReader, error) {
+	if tracker == nil {
+		return openCsvFile(filePath, csvCommaOf(filePath), nil)
+	}
+	in, err := tracker.openInput(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openCsvStream(in, false, csvCommaOf(filePath), nil)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &csvInputReader{r, in, tracker}, nil
+}
+
+type csvInputReader struct {
+	r       *csvStreamReader
+	in      *progressInput
+	tracker *progressTracker
+}
+
+func (t *csvInputReader) ReadHeader() (CsvFile, error) {
+	header, err := t.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return newCsvFile(header, t), nil
+}
+
+func (t *csvInputReader) Read() ([]string, error) {
+	record, err := t.r.Read()
+	if err == nil {
+		t.tracker.add(1, 0, 0)
+	}
+	return record, err
+}
+
+func (t *csvInputReader) Close() error {
+	t.r.Close()
+	return t.in.Close()
+}
+
+// openJsonInput opens the json input of the conversion, with the tracker the bytes and records read are counted.
+func openJsonInput(filePath string, tracker *progressTracker) (JsonReader, error) {
+	if tracker == nil {
+		return OpenJsonFile(filePath)
+	}
+	in, err := tracker.openInput(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := JsonStreamFormat(in, false, JsonFormatOf(filePath))
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &jsonInputReader{r, in, tracker}, nil
+}
+
+type jsonInputReader struct {
+	r       JsonReader
+	in      *progressInput
+	tracker *progressTracker
+}
+
+func (t *jsonInputReader) ReadRaw() (json.RawMessage, error) {
+	jsonBin, err := t.r.ReadRaw()
+	if err == nil {
+		t.tracker.add(1, 0, 0)
+	}
+	return jsonBin, err
+}
+
+func (t *jsonInputReader) Read(holder interface{}) error {
+	jsonBin, err := t.ReadRaw()
+	if err != nil {
+		return err
+	}
+	return Marshaler.Unmarshal(jsonBin, holder)
+}
+
+func (t *jsonInputReader) Close() error {
+	t.r.Close()
+	return t.in.Close()
+}
+
+// openProtoInput opens the length-delimited proto input of the conversion, with the tracker the bytes and records read are counted.
+func openProtoInput(filePath string, tracker *progressTracker) (ProtoReader, error) {
+	if tracker == nil {
+		return OpenProtoFile(filePath)
+	}
+	in, err := tracker.openInput(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ProtoStream(in, false)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	return &protoInputReader{r, in, tracker}, nil
+}
+
+type protoInputReader struct {
+	r       ProtoReader
+	in      *progressInput
+	tracker *progressTracker
+}
+
+func (t *protoInputReader) ReadTo(message protov1.Message) error {
+	block, err := t.ReadRaw()
+	if err != nil {
+		return err
+	}
+	return protov1.Unmarshal(block, message)
+}
+
+func (t *protoInputReader) ReadRaw() ([]byte, error) {
+	block, err := t.r.ReadRaw()
+	if err == nil {
+		t.tracker.add(1, 0, 0)
+	}
+	return block, err
+}
+
+func (t *protoInputReader) Close() error {
+	t.r.Close()
+	return t.in.Close()
+}
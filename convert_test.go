@@ -0,0 +1,151 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"google.golang.org/protobuf/reflect/protodesc"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestConvertFile(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "convert-test")
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+
+	protoFilePath := filePath + ".pb.gz"
+	csvFilePath := filePath + ".csv"
+	jsonFilePath := filePath + ".jsonl.gz"
+	resultFilePath := filePath + "_result.pb"
+	defer os.Remove(protoFilePath)
+	defer os.Remove(csvFilePath)
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(resultFilePath)
+
+	pf, err := files.NewProtoFile(protoFilePath)
+	require.NoError(t, err)
+	expected := []*Domain{
+		{Domain: "www.example.com", Zone: "example.com", Options: []string{"zone", "ip"}, SelfIssuer: &SelfIssuer{Certificate: []byte("cert")}},
+		{Domain: "obj2", DnsProvider: "dns"},
+	}
+	for _, obj := range expected {
+		_, err = pf.Write(obj)
+		require.NoError(t, err)
+	}
+	require.NoError(t, pf.Close())
+
+	opts := files.ConvertOptions{
+		Message: proto.MessageV2(&Domain{}).ProtoReflect().Descriptor(),
+		Columns: map[string]string{"name": "domain"},
+	}
+
+	report, err := files.ConvertFile(protoFilePath, csvFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Records)
+
+	content, err := ioutil.ReadFile(csvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, "name,zone,options,dns_provider,cert_provider,certificates,self_issuer,acme_account\n"+
+		"www.example.com,example.com,\"[\"\"zone\"\",\"\"ip\"\"]\",,,,\"{\"\"certificate\"\":\"\"Y2VydA==\"\"}\",\n"+
+		"obj2,,,dns,,,,\n", string(content))
+
+	report, err = files.ConvertFile(csvFilePath, jsonFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Records)
+
+	report, err = files.ConvertFile(jsonFilePath, resultFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Records)
+
+	reader, err := files.OpenProtoFile(resultFilePath)
+	require.NoError(t, err)
+	for _, exp := range expected {
+		var obj Domain
+		require.NoError(t, reader.ReadTo(&obj))
+		require.True(t, proto.Equal(exp, &obj), obj.String())
+	}
+	require.Equal(t, io.EOF, reader.ReadTo(&Domain{}))
+	require.NoError(t, reader.Close())
+}
+
+func TestConvertErrors(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "convert-test")
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+
+	csvFilePath := filePath + ".csv"
+	protoFilePath := filePath + ".pb"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(protoFilePath)
+
+	err = ioutil.WriteFile(csvFilePath, []byte("domain,options,unknown\nobj1,\"[\"\"a\"\"]\",x\nobj2,[1,x\nobj3,,\n"), 0644)
+	require.NoError(t, err)
+
+	opts := files.ConvertOptions{
+		Message: proto.MessageV2(&Domain{}).ProtoReflect().Descriptor(),
+	}
+
+	_, err = files.ConvertFile(csvFilePath, protoFilePath, opts)
+	require.Error(t, err)
+
+	opts.IgnoreUnknown = true
+	_, err = files.ConvertFile(csvFilePath, protoFilePath, opts)
+	require.Error(t, err)
+	_, err = os.Stat(protoFilePath)
+	require.True(t, os.IsNotExist(err))
+
+	var failed []int
+	opts.OnError = func(record int, err error) error {
+		failed = append(failed, record)
+		return nil
+	}
+	report, err := files.ConvertFile(csvFilePath, protoFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Records)
+	require.Equal(t, 1, report.Errors)
+	require.Equal(t, []int{1}, failed)
+
+	_, err = files.ConvertFile(csvFilePath, filePath+".txt", opts)
+	require.Error(t, err)
+}
+
+func TestLoadMessageDescriptor(t *testing.T) {
+
+	md := proto.MessageV2(&Domain{}).ProtoReflect().Descriptor()
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(md.ParentFile())},
+	}
+	content, err := protov2.Marshal(set)
+	require.NoError(t, err)
+
+	fd, err := ioutil.TempFile(os.TempDir(), "convert-test")
+	require.NoError(t, err)
+	_, err = fd.Write(content)
+	require.NoError(t, err)
+	fd.Close()
+	defer os.Remove(fd.Name())
+
+	loaded, err := files.LoadMessageDescriptor(fd.Name(), string(md.FullName()))
+	require.NoError(t, err)
+	require.Equal(t, md.FullName(), loaded.FullName())
+	require.Equal(t, md.Fields().Len(), loaded.Fields().Len())
+
+	_, err = files.LoadMessageDescriptor(fd.Name(), "unknown.Message")
+	require.Error(t, err)
+}
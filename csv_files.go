@@ -14,6 +14,14 @@ import (
 	"strings"
 )
 
+// csvCommaOf is the delimiter of the registered csv or tsv format of the file, the legacy constructors are comma-only.
+func csvCommaOf(filePath string) rune {
+	if strings.HasSuffix(strings.TrimSuffix(filePath, ".gz"), ".tsv") {
+		return '\t'
+	}
+	return ','
+}
+
 type csvStreamWriter struct {
 	fw   io.Writer
 	gzw   *gzip.Writer
@@ -22,6 +30,14 @@ type csvStreamWriter struct {
 }
 
 func NewCsvStream(fw io.Writer, gzipEnabled bool, valueProcessors ...CsvValueProcessor) CsvWriter {
+	return newCsvStream(fw, gzipEnabled, ',', valueProcessors)
+}
+
+func NewTsvStream(fw io.Writer, gzipEnabled bool, valueProcessors ...CsvValueProcessor) CsvWriter {
+	return newCsvStream(fw, gzipEnabled, '\t', valueProcessors)
+}
+
+func newCsvStream(fw io.Writer, gzipEnabled bool, comma rune, valueProcessors []CsvValueProcessor) *csvStreamWriter {
 
 	t := &csvStreamWriter{
 		fw:              fw,
@@ -35,16 +51,21 @@ func NewCsvStream(fw io.Writer, gzipEnabled bool, valueProcessors ...CsvValuePro
 		t.csvw = csv.NewWriter(t.fw)
 	}
 
+	t.csvw.Comma = comma
 	return t
 }
 
-func (t *csvStreamWriter) Close() (err error) {
-	t.csvw.Flush()
-	if t.gzw != nil {
-		t.gzw.Flush()
-		err = t.gzw.Close()
-	}
-	return err
+func (t *csvStreamWriter) Close() error {
+	return closeLayers(csvFlush(t.csvw), gzipClose(t.gzw))
+}
+
+func (t *csvStreamWriter) Flush() error {
+	return flushLayers(csvFlush(t.csvw), gzipFlush(t.gzw))
+}
+
+// Sync flushes the writer to a gzip sync point and syncs the stream if it is a file.
+func (t *csvStreamWriter) Sync() error {
+	return flushLayers(t.Flush, writerSync(t.fw))
 }
 
 func (t *csvStreamWriter) Write(values ...string) error {
@@ -64,36 +85,54 @@ type csvFileWriter struct {
 }
 
 func NewCsvFile(filePath string, valueProcessors ...CsvValueProcessor) (CsvWriter, error) {
+	return createCsvFile(filePath, ',', valueProcessors)
+}
 
-	var err error
-	t := new(csvFileWriter)
-	t.valueProcessors = valueProcessors
+func NewTsvFile(filePath string, valueProcessors ...CsvValueProcessor) (CsvWriter, error) {
+	return createCsvFile(filePath, '\t', valueProcessors)
+}
 
-	t.fd, err = os.Create(filePath)
+func createCsvFile(filePath string, comma rune, valueProcessors []CsvValueProcessor) (*csvFileWriter, error) {
+
+	fd, err := createFile(filePath)
 	if err != nil {
 		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
 	}
 
+	return newCsvFileWriter(fd, strings.HasSuffix(filePath, ".gz"), comma, valueProcessors), nil
+}
+
+func newCsvFileWriter(fd *os.File, gzipEnabled bool, comma rune, valueProcessors []CsvValueProcessor) *csvFileWriter {
+
+	t := &csvFileWriter{
+		fd:              fd,
+		valueProcessors: valueProcessors,
+	}
+
 	t.fw = bufio.NewWriterSize(t.fd, FileRWBlockSize)
 
-	if strings.HasSuffix(filePath, ".gz") {
+	if gzipEnabled {
 		t.gzw = gzip.NewWriter(t.fw)
 		t.csvw = csv.NewWriter(t.gzw)
 	} else {
 		t.csvw = csv.NewWriter(t.fw)
 	}
 
-	return t, nil
+	t.csvw.Comma = comma
+	return t
 }
 
 func (t *csvFileWriter) Close() error {
-	t.csvw.Flush()
-	if t.gzw != nil {
-		t.gzw.Flush()
-		t.gzw.Close()
-	}
-	t.fw.Flush()
-	return t.fd.Close()
+	return closeLayers(csvFlush(t.csvw), gzipClose(t.gzw), t.fw.Flush, syncOnClose(t.fd), t.fd.Close)
+}
+
+func (t *csvFileWriter) Flush() error {
+	return flushLayers(csvFlush(t.csvw), gzipFlush(t.gzw), t.fw.Flush)
+}
+
+// Sync flushes all layers, the gzip stream to a decodable sync point, and commits the file to disk.
+func (t *csvFileWriter) Sync() error {
+	return flushLayers(t.Flush, t.fd.Sync)
 }
 
 func (t *csvFileWriter) Write(values ...string) error {
@@ -123,6 +162,14 @@ type csvStreamReader struct {
 }
 
 func OpenCsvStream(fr io.Reader, gzipEnabled bool, valueProcessors ...CsvValueProcessor) (CsvStream, error) {
+	return openCsvStream(fr, gzipEnabled, ',', valueProcessors)
+}
+
+func OpenTsvStream(fr io.Reader, gzipEnabled bool, valueProcessors ...CsvValueProcessor) (CsvStream, error) {
+	return openCsvStream(fr, gzipEnabled, '\t', valueProcessors)
+}
+
+func openCsvStream(fr io.Reader, gzipEnabled bool, comma rune, valueProcessors []CsvValueProcessor) (*csvStreamReader, error) {
 
 	var err error
 	t := &csvStreamReader{
@@ -140,6 +187,7 @@ func OpenCsvStream(fr io.Reader, gzipEnabled bool, valueProcessors ...CsvValuePr
 		t.csvr = csv.NewReader(t.fr)
 	}
 
+	t.csvr.Comma = comma
 	return t, nil
 
 }
@@ -171,16 +219,33 @@ type csvFileReader struct {
 }
 
 func OpenCsvFile(filePath string, valueProcessors ...CsvValueProcessor) (CsvReader, error) {
+	return openCsvFile(filePath, ',', valueProcessors)
+}
+
+func OpenTsvFile(filePath string, valueProcessors ...CsvValueProcessor) (CsvReader, error) {
+	return openCsvFile(filePath, '\t', valueProcessors)
+}
+
+func openCsvFile(filePath string, comma rune, valueProcessors []CsvValueProcessor) (*csvFileReader, error) {
 
 	fd, err := os.Open(filePath)
 	if err != nil {
 		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
 	}
 
-	return CsvFileReader(fd, valueProcessors...)
+	t, err := csvFileReaderComma(fd, comma, valueProcessors)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return t, nil
 }
 
 func CsvFileReader(fd *os.File, valueProcessors ...CsvValueProcessor) (*csvFileReader, error) {
+	return csvFileReaderComma(fd, ',', valueProcessors)
+}
+
+func csvFileReaderComma(fd *os.File, comma rune, valueProcessors []CsvValueProcessor) (*csvFileReader, error) {
 
 	var err error
 	t := &csvFileReader{
@@ -200,6 +265,7 @@ func CsvFileReader(fd *os.File, valueProcessors ...CsvValueProcessor) (*csvFileR
 		t.csvr = csv.NewReader(t.fr)
 	}
 
+	t.csvr.Comma = comma
 	return t, nil
 
 }
@@ -352,124 +418,11 @@ func (t csvSchemaRecord) Fields() map[string]string {
 }
 
 func SplitCsvFile(inputFilePath string, limit int, partFn func (int) string) ([]string, error) {
-
-	reader, err := OpenCsvFile(inputFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-
-	header, err := reader.Read()
-	if err != nil {
-		return nil, err
-	}
-
-	var parts []string
-	var writer CsvWriter
-
-	partNum := 1
-	for cnt := limit; err == nil; cnt++ {
-
-		row, err := reader.Read()
-		if err != nil {
-			break
-		}
-
-		if cnt == limit {
-			if writer != nil {
-				writer.Close()
-				writer = nil
-			}
-			partFilePath := partFn(partNum)
-			writer, err = NewCsvFile(partFilePath)
-			if err != nil {
-				break
-			}
-			parts = append(parts, partFilePath)
-			err = writer.Write(header...)
-			if err != nil {
-				break
-			}
-			cnt = 0
-			partNum++
-		}
-
-		err = writer.Write(row...)
-	}
-
-	if err == io.EOF {
-		err = nil
-	}
-
-	if writer != nil {
-		writer.Close()
-	}
-
-	if err != nil {
-		for _, part := range parts {
-			os.Remove(part)
-		}
-		parts = nil
-	}
-
-	return parts, err
+	return SplitCsvFileWith(inputFilePath, SplitOptions{Limit: limit}, partFn)
 }
 
+// JoinCsvFiles joins csv files with the same header, use JoinCsvFilesWith to reorder or unite different headers.
 func JoinCsvFiles(outputFilePath string, parts []string) error {
-
-	writer, err := NewCsvFile(outputFilePath)
-	if err != nil {
-		return err
-	}
-	defer writer.Close()
-
-	for i, part := range parts {
-
-		reader, err := OpenCsvFile(part)
-		if err != nil {
-			return errors.Errorf("can not open file '%s', %v", part, err)
-		}
-
-		header, err := reader.Read()
-		if err != nil {
-			reader.Close()
-			return errors.Errorf("can not read header in file '%s', %v", part, err)
-		}
-
-		if i == 0 {
-			err = writer.Write(header...)
-			if err != nil {
-				reader.Close()
-				return errors.Errorf("can not write header to file '%s', %v", outputFilePath, err)
-			}
-		}
-
-		for {
-
-			row, err := reader.Read()
-			if err != nil {
-				break
-			}
-
-			err = writer.Write(row...)
-			if err != nil {
-				reader.Close()
-				return errors.Errorf("can not write row to file '%s', %v", outputFilePath, err)
-			}
-
-		}
-
-		if err == io.EOF {
-			err = nil
-		}
-
-		reader.Close()
-
-		if err != nil {
-			return errors.Errorf("join read file '%s', %v", part, err)
-		}
-
-	}
-
-	return nil
+	_, err := JoinCsvFilesWith(outputFilePath, parts, JoinOptions{})
+	return err
 }
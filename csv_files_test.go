@@ -53,6 +53,75 @@ func TestCsvWriteAndRead(t *testing.T) {
 
 }
 
+func TestTsvFileOptIn(t *testing.T) {
+
+	filePath := tempFilePath(t, "csv-test") + ".tsv"
+	defer os.Remove(filePath)
+
+	// legacy constructors keep the comma for any extension
+	writer, err := files.NewCsvFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write("a", "b"))
+	require.NoError(t, writer.Close())
+
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "a,b\n", string(content))
+
+	reader, err := files.OpenCsvFile(filePath)
+	require.NoError(t, err)
+	record, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, record)
+	require.NoError(t, reader.Close())
+
+	writer, err = files.NewTsvFile(filePath + ".gz")
+	require.NoError(t, err)
+	defer os.Remove(filePath + ".gz")
+	require.NoError(t, writer.Write("a,1", "b"))
+	require.NoError(t, writer.Close())
+
+	reader, err = files.OpenTsvFile(filePath + ".gz")
+	require.NoError(t, err)
+	record, err = reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a,1", "b"}, record)
+	require.NoError(t, reader.Close())
+
+	// legacy split and join keep the comma for any extension
+	writer, err = files.NewCsvFile(filePath)
+	require.NoError(t, err)
+	for _, row := range [][]string{{"name", "count"}, {"a", "1"}, {"b", "2"}, {"c", "3"}} {
+		require.NoError(t, writer.Write(row...))
+	}
+	require.NoError(t, writer.Close())
+
+	parts, err := files.SplitCsvFile(filePath, 2, func(i int) string {
+		return fmt.Sprintf("%s_part%d.tsv", filePath, i)
+	})
+	require.NoError(t, err)
+	for _, part := range parts {
+		defer os.Remove(part)
+	}
+	require.Equal(t, 2, len(parts))
+	content, err = ioutil.ReadFile(parts[1])
+	require.NoError(t, err)
+	require.Equal(t, "name,count\nc,3\n", string(content))
+
+	joinedPath := filePath + "_joined.tsv"
+	defer os.Remove(joinedPath)
+	require.NoError(t, files.JoinCsvFiles(joinedPath, parts))
+	content, err = ioutil.ReadFile(joinedPath)
+	require.NoError(t, err)
+	require.Equal(t, "name,count\na,1\nb,2\nc,3\n", string(content))
+
+	// the registry selects the tsv format by extension
+	records, err := files.OpenFile(filePath + ".gz")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a,1", "b"}, records.Header())
+	require.NoError(t, records.Close())
+}
+
 func readCsv(t *testing.T, filePath string) {
 
 	reader, err := files.OpenCsvFile(filePath, strings.TrimSpace, files.RemoveHash)
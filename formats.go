@@ -0,0 +1,467 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Record is a format-neutral record, Values holds the columns of csv records, Raw holds
+// the JSON text of json records and the message bytes of proto records.
+type Record struct {
+	Values []string
+	Raw    []byte
+}
+
+type Format struct {
+	Name       string
+	Kind       string   // CsvRecords, JsonRecords or ProtoRecords
+	Extensions []string // extensions without compression suffix, like ".csv"
+	Open       func(r io.Reader, gzipEnabled bool) (RecordReader, error)
+	Create     func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error)
+}
+
+var (
+	CsvFormat = &Format{
+		Name:       "csv",
+		Kind:       CsvRecords,
+		Extensions: []string{".csv"},
+		Open: func(r io.Reader, gzipEnabled bool) (RecordReader, error) {
+			return openCsvRecords(r, gzipEnabled, ',')
+		},
+		Create: func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error) {
+			return newCsvRecords(newCsvStream(w, gzipEnabled, ',', nil), header)
+		},
+	}
+
+	TsvFormat = &Format{
+		Name:       "tsv",
+		Kind:       CsvRecords,
+		Extensions: []string{".tsv"},
+		Open: func(r io.Reader, gzipEnabled bool) (RecordReader, error) {
+			return openCsvRecords(r, gzipEnabled, '\t')
+		},
+		Create: func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error) {
+			return newCsvRecords(newCsvStream(w, gzipEnabled, '\t', nil), header)
+		},
+	}
+
+	JsonLinesFormat = &Format{
+		Name:       "jsonl",
+		Kind:       JsonRecords,
+		Extensions: []string{".jsonl", ".ndjson", ".json"},
+		Open: func(r io.Reader, gzipEnabled bool) (RecordReader, error) {
+			return openJsonRecords(r, gzipEnabled, JsonLines)
+		},
+		Create: func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error) {
+			return &jsonRecordWriter{NewJsonStreamFormat(w, gzipEnabled, JsonLines)}, nil
+		},
+	}
+
+	JsonSeqFormat = &Format{
+		Name:       "json-seq",
+		Kind:       JsonRecords,
+		Extensions: []string{".json-seq"},
+		Open: func(r io.Reader, gzipEnabled bool) (RecordReader, error) {
+			return openJsonRecords(r, gzipEnabled, JsonSeq)
+		},
+		Create: func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error) {
+			return &jsonRecordWriter{NewJsonStreamFormat(w, gzipEnabled, JsonSeq)}, nil
+		},
+	}
+
+	ProtoFormat = &Format{
+		Name:       "pb",
+		Kind:       ProtoRecords,
+		Extensions: []string{".pb"},
+		Open: func(r io.Reader, gzipEnabled bool) (RecordReader, error) {
+			reader, err := ProtoStream(r, gzipEnabled)
+			if err != nil {
+				return nil, err
+			}
+			return &protoRecordReader{reader}, nil
+		},
+		Create: func(w io.Writer, gzipEnabled bool, header []string) (RecordWriter, error) {
+			return &protoRecordWriter{NewProtoStream(w, gzipEnabled)}, nil
+		},
+	}
+)
+
+var formats = struct {
+	sync.RWMutex
+	byExtension map[string]*Format
+}{
+	byExtension: make(map[string]*Format),
+}
+
+func init() {
+	for _, format := range []*Format{CsvFormat, TsvFormat, JsonLinesFormat, JsonSeqFormat, ProtoFormat} {
+		RegisterFormat(format)
+	}
+}
+
+// RegisterFormat adds or replaces the format for all of its extensions.
+func RegisterFormat(format *Format) {
+	formats.Lock()
+	defer formats.Unlock()
+	for _, ext := range format.Extensions {
+		formats.byExtension[ext] = format
+	}
+}
+
+// FormatOf finds the format by the longest registered extension of the file name,
+// a ".gz" suffix enables compression, like in ".json.gz" or ".pb.gz".
+func FormatOf(filePath string) (format *Format, gzipEnabled bool, err error) {
+
+	name := filePath
+	if strings.HasSuffix(name, ".gz") {
+		name, gzipEnabled = strings.TrimSuffix(name, ".gz"), true
+	}
+
+	formats.RLock()
+	defer formats.RUnlock()
+
+	extensions := make([]string, 0, len(formats.byExtension))
+	for ext := range formats.byExtension {
+		extensions = append(extensions, ext)
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		return len(extensions[i]) > len(extensions[j])
+	})
+
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return formats.byExtension[ext], gzipEnabled, nil
+		}
+	}
+
+	return nil, false, errors.Errorf("unknown record format of '%s'", filePath)
+}
+
+func OpenFile(filePath string) (RecordReader, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.OpenFile(filePath, gzipEnabled)
+}
+
+func CreateFile(filePath string, header []string) (RecordWriter, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.CreateFile(filePath, gzipEnabled, header)
+}
+
+func (t *Format) OpenFile(filePath string, gzipEnabled bool) (RecordReader, error) {
+
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+
+	reader, err := t.Open(bufio.NewReaderSize(fd, FileRWBlockSize), gzipEnabled)
+	if err != nil {
+		fd.Close()
+		return nil, errors.Errorf("open %s records in '%s', %v", t.Name, filePath, err)
+	}
+
+	return &fileRecordReader{reader, fd}, nil
+}
+
+func (t *Format) CreateFile(filePath string, gzipEnabled bool, header []string) (RecordWriter, error) {
+
+	fd, err := createFile(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
+	}
+
+	fw := bufio.NewWriterSize(fd, FileRWBlockSize)
+
+	writer, err := t.Create(fw, gzipEnabled, header)
+	if err != nil {
+		fd.Close()
+		os.Remove(filePath)
+		return nil, errors.Errorf("create %s records in '%s', %v", t.Name, filePath, err)
+	}
+
+	return &fileRecordWriter{writer, fw, fd}, nil
+}
+
+type fileRecordReader struct {
+	RecordReader
+	fd *os.File
+}
+
+func (t *fileRecordReader) Close() error {
+	t.RecordReader.Close()
+	return t.fd.Close()
+}
+
+type fileRecordWriter struct {
+	RecordWriter
+	fw *bufio.Writer
+	fd *os.File
+}
+
+func (t *fileRecordWriter) Flush() error {
+	return flushLayers(t.RecordWriter.Flush, t.fw.Flush)
+}
+
+func (t *fileRecordWriter) Close() error {
+	return closeLayers(t.RecordWriter.Close, t.fw.Flush, syncOnClose(t.fd), t.fd.Close)
+}
+
+type csvRecordReader struct {
+	r      CsvStream
+	header []string
+}
+
+func openCsvRecords(r io.Reader, gzipEnabled bool, comma rune) (RecordReader, error) {
+	reader, err := openCsvStream(r, gzipEnabled, comma, nil)
+	if err != nil {
+		return nil, err
+	}
+	header, err := reader.Read()
+	if err != nil && err != io.EOF {
+		reader.Close()
+		return nil, errors.Errorf("can not read header, %v", err)
+	}
+	return &csvRecordReader{reader, header}, nil
+}
+
+func (t *csvRecordReader) Header() []string {
+	return t.header
+}
+
+func (t *csvRecordReader) Read() (Record, error) {
+	values, err := t.r.Read()
+	return Record{Values: values}, err
+}
+
+func (t *csvRecordReader) Close() error {
+	return t.r.Close()
+}
+
+type csvRecordWriter struct {
+	w CsvWriter
+}
+
+func newCsvRecords(w CsvWriter, header []string) (RecordWriter, error) {
+	if header != nil {
+		if err := w.Write(header...); err != nil {
+			return nil, err
+		}
+	}
+	return &csvRecordWriter{w}, nil
+}
+
+func (t *csvRecordWriter) Write(record Record) error {
+	if record.Values == nil && record.Raw != nil {
+		return errors.New("csv writer needs record values")
+	}
+	return t.w.Write(record.Values...)
+}
+
+func (t *csvRecordWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *csvRecordWriter) Close() error {
+	return t.w.Close()
+}
+
+type jsonRecordReader struct {
+	r JsonReader
+}
+
+func openJsonRecords(r io.Reader, gzipEnabled bool, format JsonFormat) (RecordReader, error) {
+	reader, err := JsonStreamFormat(r, gzipEnabled, format)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonRecordReader{reader}, nil
+}
+
+func (t *jsonRecordReader) Header() []string {
+	return nil
+}
+
+func (t *jsonRecordReader) Read() (Record, error) {
+	raw, err := t.r.ReadRaw()
+	return Record{Raw: raw}, err
+}
+
+func (t *jsonRecordReader) Close() error {
+	return t.r.Close()
+}
+
+type jsonRecordWriter struct {
+	w JsonWriter
+}
+
+func (t *jsonRecordWriter) Write(record Record) error {
+	if record.Raw == nil && record.Values != nil {
+		return errors.New("json writer needs raw record")
+	}
+	return t.w.WriteRaw(record.Raw)
+}
+
+func (t *jsonRecordWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *jsonRecordWriter) Close() error {
+	return t.w.Close()
+}
+
+type protoRecordReader struct {
+	r ProtoReader
+}
+
+func (t *protoRecordReader) Header() []string {
+	return nil
+}
+
+func (t *protoRecordReader) Read() (Record, error) {
+	raw, err := t.r.ReadRaw()
+	return Record{Raw: raw}, err
+}
+
+func (t *protoRecordReader) Close() error {
+	return t.r.Close()
+}
+
+type protoRecordWriter struct {
+	w ProtoWriter
+}
+
+func (t *protoRecordWriter) Write(record Record) error {
+	if record.Raw == nil && record.Values != nil {
+		return errors.New("proto writer needs raw record")
+	}
+	return t.w.WriteRaw(record.Raw)
+}
+
+func (t *protoRecordWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *protoRecordWriter) Close() error {
+	return t.w.Close()
+}
+
+func flushWriter(w interface{}) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// CopyRecords copies all records, the formats must store the same kind of records.
+func CopyRecords(writer RecordWriter, reader RecordReader) (int64, error) {
+	var cnt int64
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return cnt, err
+		}
+		if err = writer.Write(record); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+}
+
+// CopyFile copies records between files of the same kind, like csv.gz to tsv or jsonl to json-seq.
+func CopyFile(inputFilePath, outputFilePath string) (int64, error) {
+
+	reader, err := OpenFile(inputFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	writer, err := CreateFile(outputFilePath, reader.Header())
+	if err != nil {
+		return 0, err
+	}
+
+	cnt, err := CopyRecords(writer, reader)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+	}
+	return cnt, err
+}
+
+func CountFile(filePath string) (int64, error) {
+
+	reader, err := OpenFile(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	var cnt int64
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return cnt, nil
+		}
+		if err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+}
+
+// SampleRecords returns up to n records chosen uniformly by reservoir sampling, in the input order.
+func SampleRecords(reader RecordReader, n int, rnd *rand.Rand) ([]Record, error) {
+
+	type sampled struct {
+		pos    int64
+		record Record
+	}
+
+	var list []sampled
+	for pos := int64(0); ; pos++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(list) < n {
+			list = append(list, sampled{pos, record})
+		} else if j := rnd.Int63n(pos + 1); j < int64(n) {
+			list[j] = sampled{pos, record}
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].pos < list[j].pos
+	})
+
+	records := make([]Record, len(list))
+	for i, s := range list {
+		records[i] = s.record
+	}
+	return records, nil
+}
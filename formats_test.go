@@ -0,0 +1,148 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestFormatOf(t *testing.T) {
+
+	cases := []struct {
+		filePath string
+		format   *files.Format
+		gzip     bool
+	}{
+		{"a.csv", files.CsvFormat, false},
+		{"a.tsv.gz", files.TsvFormat, true},
+		{"dir.v1/a.jsonl", files.JsonLinesFormat, false},
+		{"a.json.gz", files.JsonLinesFormat, true},
+		{"a.ndjson", files.JsonLinesFormat, false},
+		{"a.json-seq.gz", files.JsonSeqFormat, true},
+		{"a.pb", files.ProtoFormat, false},
+		{"a.pb.gz", files.ProtoFormat, true},
+	}
+
+	for _, c := range cases {
+		format, gzipEnabled, err := files.FormatOf(c.filePath)
+		require.NoError(t, err, c.filePath)
+		require.Equal(t, c.format, format, c.filePath)
+		require.Equal(t, c.gzip, gzipEnabled, c.filePath)
+	}
+
+	_, _, err := files.FormatOf("a.txt")
+	require.Error(t, err)
+
+	_, _, err = files.FormatOf("a.gz")
+	require.Error(t, err)
+
+	files.RegisterFormat(&files.Format{Name: "test-lines", Kind: files.JsonRecords, Extensions: []string{".test.jsonl"}, Open: files.JsonLinesFormat.Open, Create: files.JsonLinesFormat.Create})
+	format, _, err := files.FormatOf("a.test.jsonl.gz")
+	require.NoError(t, err)
+	require.Equal(t, "test-lines", format.Name)
+}
+
+func tempFilePath(t *testing.T, prefix string) string {
+	fd, err := ioutil.TempFile(os.TempDir(), prefix)
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+	return filePath
+}
+
+func TestCopyAndCountFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "formats-test")
+
+	csvFilePath := filePath + ".csv.gz"
+	tsvFilePath := filePath + ".tsv"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(tsvFilePath)
+
+	csv, err := files.NewCsvFile(csvFilePath)
+	require.NoError(t, err)
+	require.NoError(t, csv.Write("name", "value"))
+	require.NoError(t, csv.Write("a,b", "1"))
+	require.NoError(t, csv.Write("c", "2"))
+	require.NoError(t, csv.Close())
+
+	cnt, err := files.CopyFile(csvFilePath, tsvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+
+	content, err := ioutil.ReadFile(tsvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, "name\tvalue\na,b\t1\nc\t2\n", string(content))
+
+	cnt, err = files.CountFile(tsvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+
+	jsonFilePath := filePath + ".jsonl"
+	seqFilePath := filePath + ".json-seq.gz"
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(seqFilePath)
+
+	writeJson(t, jsonFilePath)
+
+	cnt, err = files.CopyFile(jsonFilePath, seqFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+	readJson(t, seqFilePath)
+
+	protoFilePath := filePath + ".pb"
+	protoGzFilePath := filePath + ".pb.gz"
+	defer os.Remove(protoFilePath)
+	defer os.Remove(protoGzFilePath)
+
+	writeProto(t, protoFilePath)
+
+	cnt, err = files.CopyFile(protoFilePath, protoGzFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cnt)
+	readProto(t, protoGzFilePath)
+
+	// different kinds of records
+	_, err = files.CopyFile(csvFilePath, filePath+".out.jsonl")
+	require.Error(t, err)
+	_, err = os.Stat(filePath + ".out.jsonl")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSampleRecords(t *testing.T) {
+
+	filePath := tempFilePath(t, "formats-test") + ".jsonl"
+	defer os.Remove(filePath)
+
+	writer, err := files.CreateFile(filePath, nil)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Raw: []byte(strconv.Itoa(i))}))
+	}
+	require.NoError(t, writer.Close())
+
+	reader, err := files.OpenFile(filePath)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	sample, err := files.SampleRecords(reader, 10, rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	require.Equal(t, 10, len(sample))
+
+	prev := -1
+	for _, record := range sample {
+		n, err := strconv.Atoi(string(record.Raw))
+		require.NoError(t, err)
+		require.True(t, n > prev)
+		prev = n
+	}
+}
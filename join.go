@@ -0,0 +1,400 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func sameHeader(a, b []string) bool {
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
+
+// HeaderPolicy selects how Join reconciles csv parts with different headers.
+type HeaderPolicy int
+
+const (
+	// HeaderFail fails on any difference from the header of the first part
+	HeaderFail HeaderPolicy = iota
+	// HeaderReorder moves the columns to the order of the first part, the column sets must be equal
+	HeaderReorder
+	// HeaderUnion writes all columns of all parts, missing columns are filled with NullValue
+	HeaderUnion
+)
+
+type JoinOptions struct {
+	Manifest  *Manifest // verifies checksums of the parts before and record counts after joining, parts default to its paths
+	Headers   HeaderPolicy
+	NullValue string           // value of the columns missing in a part for HeaderUnion
+	Workers   int              // goroutines reading the parts and encoding the output in parallel variants, 0 for GOMAXPROCS
+	Progress  ProgressObserver // reports reading the part files, optional
+}
+
+type JoinReport struct {
+	Header  []string
+	Records int64
+	Parts   []JoinPartReport
+}
+
+type JoinPartReport struct {
+	Path      string
+	Records   int64
+	Reordered bool     // relative order of the part columns was changed
+	Missing   []string // output columns filled with the null value
+}
+
+// columnMapper writes csv records in the output column order, index holds the part column of each output column or -1.
+type columnMapper struct {
+	RecordWriter
+	index []int
+	null  string
+}
+
+func (t *columnMapper) mapRecord(record Record) Record {
+	values := make([]string, len(t.index))
+	for i, j := range t.index {
+		if j >= 0 && j < len(record.Values) {
+			values[i] = record.Values[j]
+		} else {
+			values[i] = t.null
+		}
+	}
+	return Record{Values: values}
+}
+
+func (t *columnMapper) Write(record Record) error {
+	return t.RecordWriter.Write(t.mapRecord(record))
+}
+
+func headerIndex(header []string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		if _, ok := index[name]; ok {
+			return nil, errors.Errorf("duplicate column '%s'", name)
+		}
+		index[name] = i
+	}
+	return index, nil
+}
+
+// reconcileHeader maps the part header to the output header and fills the report of the part.
+func reconcileHeader(header, partHeader []string, opts JoinOptions, report *JoinPartReport) ([]int, error) {
+
+	if sameHeader(header, partHeader) {
+		return nil, nil
+	}
+	if opts.Headers == HeaderFail {
+		return nil, errors.Errorf("header %v does not match %v", partHeader, header)
+	}
+
+	index, err := headerIndex(partHeader)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Headers == HeaderReorder && len(partHeader) != len(header) {
+		return nil, errors.Errorf("columns %v can not be reordered to %v", partHeader, header)
+	}
+
+	mapping := make([]int, len(header))
+	last := -1
+	for i, name := range header {
+		j, ok := index[name]
+		if !ok {
+			if opts.Headers == HeaderReorder {
+				return nil, errors.Errorf("column '%s' is missing", name)
+			}
+			j = -1
+			report.Missing = append(report.Missing, name)
+		} else {
+			if j < last {
+				report.Reordered = true
+			}
+			last = j
+		}
+		mapping[i] = j
+	}
+	return mapping, nil
+}
+
+// partHeaderOf reads the header of the part file, nil for formats without a header.
+func partHeaderOf(kind string, part string) ([]string, error) {
+	reader, err := openKindFile(kind, part)
+	if err != nil {
+		return nil, errors.Errorf("can not open file '%s', %v", part, err)
+	}
+	defer reader.Close()
+	return reader.Header(), nil
+}
+
+// unionHeader reads the headers of all parts and returns the columns in order of appearance.
+func unionHeader(kind string, parts []string) ([]string, error) {
+	var header []string
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		partHeader, err := partHeaderOf(kind, part)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := headerIndex(partHeader); err != nil {
+			return nil, errors.Errorf("header of file '%s', %v", part, err)
+		}
+		for _, name := range partHeader {
+			if !seen[name] {
+				seen[name] = true
+				header = append(header, name)
+			}
+		}
+	}
+	return header, nil
+}
+
+func joinKindFiles(ctx context.Context, kind string, outputFilePath string, parts []string, opts JoinOptions) (report *JoinReport, err error) {
+
+	if opts.Manifest != nil {
+		if parts, err = opts.Manifest.verifiedParts(parts); err != nil {
+			return nil, err
+		}
+	}
+
+	format, gzipEnabled, err := kindFileFormat(kind, outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	report = new(JoinReport)
+	if opts.Headers == HeaderUnion {
+		report.Header, err = unionHeader(kind, parts)
+	} else if len(parts) > 0 {
+		report.Header, err = partHeaderOf(kind, parts[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the output is created before the parts are read, no parts make an empty file
+	writer, err := format.CreateFile(outputFilePath, gzipEnabled, report.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, 0, len(parts))
+	tracker := newFilesProgressTracker(opts.Progress, parts)
+
+	defer func() {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && opts.Manifest != nil {
+			err = opts.Manifest.VerifyCounts(counts)
+		}
+		if err != nil {
+			os.Remove(outputFilePath)
+			report = nil
+		}
+	}()
+
+	for _, part := range parts {
+
+		reader, err := openProgressKindFile(kind, part, tracker)
+		if err != nil {
+			return nil, errors.Errorf("can not open file '%s', %v", part, err)
+		}
+
+		partReport := JoinPartReport{Path: part}
+		mapping, err := reconcileHeader(report.Header, reader.Header(), opts, &partReport)
+		if err != nil {
+			reader.Close()
+			return nil, errors.Errorf("header of file '%s', %v", part, err)
+		}
+
+		w := writer
+		if mapping != nil {
+			w = &columnMapper{writer, mapping, opts.NullValue}
+		}
+
+		partReport.Records, err = CopyRecords(w, WithContextReader(ctx, reader))
+		reader.Close()
+
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, errors.Errorf("join file '%s', %v", part, err)
+		}
+		counts = append(counts, partReport.Records)
+		report.Parts = append(report.Parts, partReport)
+		report.Records += partReport.Records
+	}
+
+	if tracker != nil {
+		tracker.finish()
+	}
+	return report, nil
+}
+
+// JoinFiles appends the records of all parts to the output, csv parts must have the same header.
+func JoinFiles(outputFilePath string, parts []string) error {
+	_, err := joinKindFiles(context.Background(), "", outputFilePath, parts, JoinOptions{})
+	return err
+}
+
+// JoinFilesWith joins any supported files, formats are selected by extension.
+func JoinFilesWith(outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(context.Background(), "", outputFilePath, parts, opts)
+}
+
+// JoinCsvFilesWith joins csv files reconciling their headers by the policy of options.
+func JoinCsvFilesWith(outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(context.Background(), CsvRecords, outputFilePath, parts, opts)
+}
+
+func JoinJsonFilesWith(outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(context.Background(), JsonRecords, outputFilePath, parts, opts)
+}
+
+func JoinProtoFilesWith(outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(context.Background(), ProtoRecords, outputFilePath, parts, opts)
+}
+
+// JoinFilesContext joins like JoinFilesWith, once the context is done it removes the output and returns ctx.Err().
+func JoinFilesContext(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(ctx, "", outputFilePath, parts, opts)
+}
+
+func JoinCsvFilesContext(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(ctx, CsvRecords, outputFilePath, parts, opts)
+}
+
+func JoinJsonFilesContext(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(ctx, JsonRecords, outputFilePath, parts, opts)
+}
+
+func JoinProtoFilesContext(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinKindFiles(ctx, ProtoRecords, outputFilePath, parts, opts)
+}
+
+// JoinStream writes the records of n parts opened by partFn to w, parts and output have the same format.
+// A manifest is verified while reading, so on error the output is incomplete and must be discarded by the caller.
+// Csv headers can be reordered but not united, since all headers are needed before writing.
+func JoinStream(w io.Writer, format *Format, gzipEnabled bool, n int, partFn func(partNum int) (io.ReadCloser, error), opts JoinOptions) error {
+	return JoinStreamContext(context.Background(), w, format, gzipEnabled, n, partFn, opts)
+}
+
+// JoinStreamContext joins like JoinStream and stops with ctx.Err() once the context is done.
+func JoinStreamContext(ctx context.Context, w io.Writer, format *Format, gzipEnabled bool, n int, partFn func(partNum int) (io.ReadCloser, error), opts JoinOptions) error {
+
+	if opts.Manifest != nil && len(opts.Manifest.Parts) != n {
+		return errors.Errorf("joining %d parts, manifest has %d", n, len(opts.Manifest.Parts))
+	}
+	if opts.Headers == HeaderUnion {
+		return errors.New("header union needs part files")
+	}
+
+	var writer RecordWriter
+	var header []string
+	counts := make([]int64, 0, n)
+
+	for partNum := 1; partNum <= n; partNum++ {
+
+		rc, err := partFn(partNum)
+		if err != nil {
+			return errors.Errorf("can not open part %d, %v", partNum, err)
+		}
+
+		cnt, err := joinStreamPart(ctx, rc, format, gzipEnabled, func(partHeader []string) (RecordWriter, error) {
+			if writer == nil {
+				var err error
+				header = partHeader
+				writer, err = format.Create(w, gzipEnabled, header)
+				return writer, err
+			}
+			mapping, err := reconcileHeader(header, partHeader, opts, &JoinPartReport{})
+			if err != nil || mapping == nil {
+				return writer, err
+			}
+			return &columnMapper{writer, mapping, opts.NullValue}, nil
+		}, opts.Manifest, partNum)
+		rc.Close()
+
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.Errorf("join part %d, %v", partNum, err)
+		}
+		counts = append(counts, cnt)
+	}
+
+	if writer == nil {
+		// no parts still make a valid empty stream, like an empty gzip member
+		var err error
+		if writer, err = format.Create(w, gzipEnabled, nil); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if opts.Manifest != nil {
+		return opts.Manifest.VerifyCounts(counts)
+	}
+	return nil
+}
+
+func joinStreamPart(ctx context.Context, r io.Reader, format *Format, gzipEnabled bool, writerFn func(header []string) (RecordWriter, error), m *Manifest, partNum int) (int64, error) {
+
+	counter := &countingWriter{w: ioutil.Discard, h: sha256.New()}
+	if m != nil {
+		r = io.TeeReader(r, counter)
+	}
+
+	reader, err := format.Open(r, gzipEnabled)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	writer, err := writerFn(reader.Header())
+	if err != nil {
+		return 0, err
+	}
+
+	cnt, err := CopyRecords(writer, WithContextReader(ctx, reader))
+	if err != nil || m == nil {
+		return cnt, err
+	}
+
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return cnt, err
+	}
+	part := m.Parts[partNum-1]
+	if counter.n != part.Bytes {
+		return cnt, errors.Errorf("part has %d bytes, manifest expects %d", counter.n, part.Bytes)
+	}
+	if sum := hex.EncodeToString(counter.h.Sum(nil)); sum != part.Sha256 {
+		return cnt, errors.Errorf("part has sha256 %s, manifest expects %s", sum, part.Sha256)
+	}
+	return cnt, nil
+}
+
+func JoinCsvStream(w io.Writer, gzipEnabled bool, n int, partFn func(partNum int) (io.ReadCloser, error), opts JoinOptions) error {
+	return JoinStream(w, CsvFormat, gzipEnabled, n, partFn, opts)
+}
+
+func JoinJsonStream(w io.Writer, gzipEnabled bool, n int, partFn func(partNum int) (io.ReadCloser, error), opts JoinOptions) error {
+	return JoinStream(w, JsonLinesFormat, gzipEnabled, n, partFn, opts)
+}
+
+func JoinProtoStream(w io.Writer, gzipEnabled bool, n int, partFn func(partNum int) (io.ReadCloser, error), opts JoinOptions) error {
+	return JoinStream(w, ProtoFormat, gzipEnabled, n, partFn, opts)
+}
@@ -0,0 +1,107 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"os"
+	"testing"
+)
+
+func writeCsvPart(t *testing.T, filePath string, header []string, rows ...[]string) {
+	writer, err := files.CreateFile(filePath, header)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, writer.Write(files.Record{Values: row}))
+	}
+	require.NoError(t, writer.Close())
+}
+
+func readCsvRows(t *testing.T, filePath string) [][]string {
+	reader, err := files.OpenFile(filePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	rows := [][]string{reader.Header()}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows
+		}
+		require.NoError(t, err)
+		rows = append(rows, record.Values)
+	}
+}
+
+func TestJoinCsvFilesHeaders(t *testing.T) {
+
+	filePath := tempFilePath(t, "join-test")
+	p1, p2, p3 := filePath+"_1.csv", filePath+"_2.csv", filePath+"_3.csv"
+	outputFilePath := filePath + "_joined.csv"
+	for _, path := range []string{p1, p2, p3, outputFilePath} {
+		defer os.Remove(path)
+	}
+
+	writeCsvPart(t, p1, []string{"a", "b", "c"}, []string{"a1", "b1", "c1"})
+	writeCsvPart(t, p2, []string{"c", "a", "b"}, []string{"c2", "a2", "b2"})
+	writeCsvPart(t, p3, []string{"a", "d"}, []string{"a3", "d3"})
+
+	err := files.JoinCsvFiles(outputFilePath, []string{p1, p2})
+	require.Error(t, err)
+	_, err = os.Stat(outputFilePath)
+	require.True(t, os.IsNotExist(err))
+
+	report, err := files.JoinCsvFilesWith(outputFilePath, []string{p1, p2}, files.JoinOptions{Headers: files.HeaderReorder})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"a", "b", "c"}, {"a1", "b1", "c1"}, {"a2", "b2", "c2"}}, readCsvRows(t, outputFilePath))
+	require.False(t, report.Parts[0].Reordered)
+	require.True(t, report.Parts[1].Reordered)
+
+	_, err = files.JoinCsvFilesWith(outputFilePath, []string{p1, p3}, files.JoinOptions{Headers: files.HeaderReorder})
+	require.Error(t, err)
+
+	report, err = files.JoinCsvFilesWith(outputFilePath, []string{p1, p2, p3}, files.JoinOptions{Headers: files.HeaderUnion, NullValue: "NULL"})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"a", "b", "c", "d"},
+		{"a1", "b1", "c1", "NULL"},
+		{"a2", "b2", "c2", "NULL"},
+		{"a3", "NULL", "NULL", "d3"},
+	}, readCsvRows(t, outputFilePath))
+	require.Equal(t, []string{"a", "b", "c", "d"}, report.Header)
+	require.Equal(t, int64(3), report.Records)
+	require.Equal(t, []string{"d"}, report.Parts[0].Missing)
+	require.Equal(t, []string{"b", "c"}, report.Parts[2].Missing)
+	require.False(t, report.Parts[2].Reordered)
+}
+
+func TestJoinNoParts(t *testing.T) {
+
+	filePath := tempFilePath(t, "join-test")
+
+	for _, ext := range []string{".csv", ".csv.gz", ".jsonl", ".pb.gz"} {
+		outputPath := filePath + ext
+		require.NoError(t, files.JoinFiles(outputPath, nil))
+		cnt, err := files.CountFile(outputPath)
+		require.NoError(t, err, ext)
+		require.Equal(t, int64(0), cnt)
+		os.Remove(outputPath)
+
+		_, err = files.JoinFilesParallel(context.Background(), outputPath, nil, files.JoinOptions{})
+		require.NoError(t, err)
+		cnt, err = files.CountFile(outputPath)
+		require.NoError(t, err, ext)
+		require.Equal(t, int64(0), cnt)
+		os.Remove(outputPath)
+	}
+
+	require.NoError(t, files.JoinCsvFiles(filePath+".csv", nil))
+	defer os.Remove(filePath + ".csv")
+	fi, err := os.Stat(filePath + ".csv")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), fi.Size())
+}
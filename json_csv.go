@@ -0,0 +1,545 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type JsonArrayMode int
+
+const (
+	ArrayIndex   JsonArrayMode = iota // every element gets own column with the index in the name, "tags.0", "tags.1"
+	ArrayJson                         // the whole array is a JSON encoded cell
+	ArrayExplode                      // every element produces own row, other columns are repeated
+)
+
+type FlattenOptions struct {
+	Separator     string        // separator of nested names, "." by default
+	Arrays        JsonArrayMode // array handling
+	SampleSize    int           // records buffered to discover the header, 1000 by default, negative to scan the whole file
+	NullValue     string        // cell value for JSON null, also recognized as null by CsvToJson
+	IgnoreUnknown bool          // drop columns that were not discovered in the sample instead of failing
+	InferTypes    bool          // CsvToJson writes numbers and booleans as JSON literals instead of strings
+}
+
+var DefaultFlattenSampleSize = 1000
+
+func (t FlattenOptions) separator() string {
+	if t.Separator == "" {
+		return "."
+	}
+	return t.Separator
+}
+
+// jsonNode keeps the key order of objects, that gives a stable column order.
+type jsonNode struct {
+	keys     []string
+	values   []*jsonNode
+	items    []*jsonNode
+	isObject bool
+	isArray  bool
+	literal  json.RawMessage // string, number, bool or null
+}
+
+func decodeJsonNode(jsonBin []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonBin))
+	dec.UseNumber()
+	return readJsonNode(dec)
+}
+
+func readJsonNode(dec *json.Decoder) (*jsonNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := token.(type) {
+	case json.Delim:
+		node := &jsonNode{}
+		if v == '{' {
+			node.isObject = true
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readJsonNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key.(string))
+				node.values = append(node.values, value)
+			}
+		} else {
+			node.isArray = true
+			for dec.More() {
+				item, err := readJsonNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case json.Number:
+		return &jsonNode{literal: json.RawMessage(v.String())}, nil
+	case nil:
+		return &jsonNode{literal: json.RawMessage("null")}, nil
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{literal: raw}, nil
+	}
+}
+
+func (t *jsonNode) marshal(buf *bytes.Buffer) {
+	switch {
+	case t.isObject:
+		buf.WriteByte('{')
+		for i, key := range t.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			t.values[i].marshal(buf)
+		}
+		buf.WriteByte('}')
+	case t.isArray:
+		buf.WriteByte('[')
+		for i, item := range t.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			item.marshal(buf)
+		}
+		buf.WriteByte(']')
+	default:
+		buf.Write(t.literal)
+	}
+}
+
+type flatCell struct {
+	name  string
+	value string
+}
+
+func (t FlattenOptions) flatten(node *jsonNode, prefix string) [][]flatCell {
+
+	name := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + t.separator() + key
+	}
+
+	switch {
+
+	case node.isObject:
+		rows := [][]flatCell{{}}
+		for i, key := range node.keys {
+			rows = crossFlatRows(rows, t.flatten(node.values[i], name(key)))
+		}
+		return rows
+
+	case node.isArray && t.Arrays == ArrayIndex:
+		rows := [][]flatCell{{}}
+		for i, item := range node.items {
+			rows = crossFlatRows(rows, t.flatten(item, name(strconv.Itoa(i))))
+		}
+		return rows
+
+	case node.isArray && t.Arrays == ArrayExplode && len(node.items) > 0:
+		var rows [][]flatCell
+		for _, item := range node.items {
+			rows = append(rows, t.flatten(item, prefix)...)
+		}
+		return rows
+
+	case node.isArray:
+		var buf bytes.Buffer
+		node.marshal(&buf)
+		return [][]flatCell{{{prefix, buf.String()}}}
+
+	default:
+		value := JsonValueString(node.literal)
+		if string(node.literal) == "null" {
+			value = t.NullValue
+		}
+		return [][]flatCell{{{prefix, value}}}
+	}
+}
+
+func crossFlatRows(left, right [][]flatCell) [][]flatCell {
+	if len(right) == 1 {
+		for i := range left {
+			left[i] = append(left[i], right[0]...)
+		}
+		return left
+	}
+	rows := make([][]flatCell, 0, len(left)*len(right))
+	for _, l := range left {
+		for _, r := range right {
+			row := make([]flatCell, 0, len(l)+len(r))
+			row = append(append(row, l...), r...)
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// FlattenJson converts one JSON record to one or more rows of named cells, top-level scalars get the name "value".
+func FlattenJson(jsonBin []byte, opts FlattenOptions) ([]map[string]string, error) {
+	rows, err := opts.flattenRaw(jsonBin)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		m := make(map[string]string, len(row))
+		for _, cell := range row {
+			m[cell.name] = cell.value
+		}
+		list[i] = m
+	}
+	return list, nil
+}
+
+func (t FlattenOptions) flattenRaw(jsonBin []byte) ([][]flatCell, error) {
+	node, err := decodeJsonNode(jsonBin)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if !node.isObject {
+		prefix = "value"
+	}
+	return t.flatten(node, prefix), nil
+}
+
+type flatHeader struct {
+	names []string
+	index map[string]int
+}
+
+func (t *flatHeader) add(rows [][]flatCell) {
+	for _, row := range rows {
+		for _, cell := range row {
+			if _, ok := t.index[cell.name]; !ok {
+				t.index[cell.name] = len(t.names)
+				t.names = append(t.names, cell.name)
+			}
+		}
+	}
+}
+
+func (t *flatHeader) write(writer CsvWriter, rows [][]flatCell, opts FlattenOptions) error {
+	for _, row := range rows {
+		values := make([]string, len(t.names))
+		for _, cell := range row {
+			idx, ok := t.index[cell.name]
+			if !ok {
+				if opts.IgnoreUnknown {
+					continue
+				}
+				if opts.SampleSize < 0 {
+					return errors.Errorf("column '%s' was not found in the records", cell.name)
+				}
+				return errors.Errorf("column '%s' was not found in the first %d records", cell.name, opts.SampleSize)
+			}
+			values[idx] = cell.value
+		}
+		if err := writer.Write(values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JsonToCsv flattens JSON records to CSV rows, the header is discovered from the first SampleSize records.
+func JsonToCsv(reader JsonReader, writer CsvWriter, opts FlattenOptions) error {
+
+	if opts.SampleSize == 0 {
+		opts.SampleSize = DefaultFlattenSampleSize
+	}
+
+	header := &flatHeader{index: make(map[string]int)}
+
+	var sample [][][]flatCell
+	for opts.SampleSize < 0 || len(sample) < opts.SampleSize {
+		raw, err := reader.ReadRaw()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := opts.flattenRaw(raw)
+		if err != nil {
+			return errors.Errorf("flatten record %d, %v", len(sample), err)
+		}
+		header.add(rows)
+		sample = append(sample, rows)
+	}
+
+	if len(sample) == 0 {
+		return nil // no records, no header
+	}
+
+	if err := writer.Write(header.names...); err != nil {
+		return err
+	}
+
+	for _, rows := range sample {
+		if err := header.write(writer, rows, opts); err != nil {
+			return err
+		}
+	}
+
+	for n := len(sample); ; n++ {
+		raw, err := reader.ReadRaw()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := opts.flattenRaw(raw)
+		if err != nil {
+			return errors.Errorf("flatten record %d, %v", n, err)
+		}
+		if err := header.write(writer, rows, opts); err != nil {
+			return errors.Errorf("record %d, %v", n, err)
+		}
+	}
+}
+
+// JsonFileToCsvFile flattens a JSON file, a negative SampleSize makes an extra pass over the input to discover all columns.
+func JsonFileToCsvFile(inputFilePath, outputFilePath string, opts FlattenOptions) error {
+
+	writer, err := NewCsvFile(outputFilePath)
+	if err != nil {
+		return err
+	}
+
+	err = flattenJsonFile(context.Background(), inputFilePath, nil, writer, opts)
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+	}
+	return err
+}
+
+func flattenJsonFile(ctx context.Context, inputFilePath string, tracker *progressTracker, writer CsvWriter, opts FlattenOptions) error {
+
+	if opts.SampleSize >= 0 {
+		reader, err := openJsonInput(inputFilePath, tracker)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return JsonToCsv(WithContextJsonReader(ctx, reader), writer, opts)
+	}
+
+	header := &flatHeader{index: make(map[string]int)}
+	records := 0
+
+	for pass := 0; pass < 2; pass++ {
+
+		reader, err := openJsonInput(inputFilePath, tracker)
+		if err != nil {
+			return err
+		}
+		reader = WithContextJsonReader(ctx, reader)
+
+		if pass == 1 {
+			err = writer.Write(header.names...)
+		}
+
+		for n := 0; err == nil; n++ {
+			var raw json.RawMessage
+			raw, err = reader.ReadRaw()
+			if err != nil {
+				break
+			}
+			var rows [][]flatCell
+			rows, err = opts.flattenRaw(raw)
+			if err != nil {
+				err = errors.Errorf("flatten record %d in '%s', %v", n, inputFilePath, err)
+			} else if pass == 0 {
+				header.add(rows)
+				records++
+			} else {
+				err = header.write(writer, rows, opts)
+			}
+		}
+
+		reader.Close()
+
+		if err != io.EOF {
+			return err
+		}
+		if records == 0 {
+			return nil // no records, no header
+		}
+	}
+
+	return nil
+}
+
+type unflatNode struct {
+	keys     []string
+	children map[string]*unflatNode
+	literal  json.RawMessage
+}
+
+func (t *unflatNode) child(key string) *unflatNode {
+	if t.children == nil {
+		t.children = make(map[string]*unflatNode)
+	}
+	c, ok := t.children[key]
+	if !ok {
+		c = &unflatNode{}
+		t.children[key] = c
+		t.keys = append(t.keys, key)
+	}
+	return c
+}
+
+// marshal writes the node, with arrays the nodes keyed by indexes below maxIndex become arrays with null gaps.
+func (t *unflatNode) marshal(buf *bytes.Buffer, arrays bool, maxIndex int) {
+
+	if t.literal != nil {
+		buf.Write(t.literal)
+		return
+	}
+
+	if arrays && len(t.keys) > 0 {
+		indexes := make([]int, 0, len(t.keys))
+		for _, key := range t.keys {
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || strconv.Itoa(i) != key {
+				indexes = nil
+				break
+			}
+			indexes = append(indexes, i)
+		}
+		if indexes != nil {
+			sort.Ints(indexes)
+		}
+		if indexes != nil && indexes[len(indexes)-1] < maxIndex {
+			buf.WriteByte('[')
+			for i := 0; i <= indexes[len(indexes)-1]; i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if c, ok := t.children[strconv.Itoa(i)]; ok {
+					c.marshal(buf, arrays, maxIndex)
+				} else {
+					buf.WriteString("null")
+				}
+			}
+			buf.WriteByte(']')
+			return
+		}
+	}
+
+	t.marshalObject(buf, arrays, maxIndex)
+}
+
+func (t *unflatNode) marshalObject(buf *bytes.Buffer, arrays bool, maxIndex int) {
+	buf.WriteByte('{')
+	for i, key := range t.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		t.children[key].marshal(buf, arrays, maxIndex)
+	}
+	buf.WriteByte('}')
+}
+
+func (t FlattenOptions) cellLiteral(value string) json.RawMessage {
+	if t.NullValue != "" && value == t.NullValue {
+		return json.RawMessage("null")
+	}
+	if t.Arrays == ArrayJson && strings.HasPrefix(value, "[") && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	if t.InferTypes {
+		switch value {
+		case "true", "false", "null":
+			return json.RawMessage(value)
+		}
+		if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+	}
+	raw, _ := json.Marshal(value)
+	return raw
+}
+
+// UnflattenCsv builds a nested JSON object from the header names split by the separator, empty cells are skipped.
+func UnflattenCsv(header []string, record []string, opts FlattenOptions) json.RawMessage {
+
+	root := &unflatNode{}
+	for i, name := range header {
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		node := root
+		for _, key := range strings.Split(name, opts.separator()) {
+			if node.literal != nil {
+				// a scalar and a nested column share the prefix, the scalar wins
+				node = nil
+				break
+			}
+			node = node.child(key)
+		}
+		if node != nil && node.children == nil {
+			node.literal = opts.cellLiteral(record[i])
+		}
+	}
+
+	// the record is an object even for numeric headers, an index can not exceed the columns of the header
+	var buf bytes.Buffer
+	root.marshalObject(&buf, opts.Arrays == ArrayIndex, len(header))
+	return buf.Bytes()
+}
+
+// CsvToJson is the inverse of JsonToCsv, exploded rows are not grouped back.
+func CsvToJson(file CsvFile, writer JsonWriter, opts FlattenOptions) error {
+	for {
+		record, err := file.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteRaw(UnflattenCsv(file.Header(), record.Record(), opts)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var testNestedJson = "{\"id\":1,\"user\":{\"name\":\"Alice\",\"tags\":[\"a\",\"b\"]},\"score\":null}\n{\"id\":2,\"user\":{\"name\":\"Bob\",\"tags\":[]}}\n"
+
+func flattenToCsv(t *testing.T, content string, opts files.FlattenOptions) string {
+
+	reader, err := files.JsonStream(bytes.NewReader([]byte(content)), false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writer := files.NewCsvStream(&buf, false)
+
+	err = files.JsonToCsv(reader, writer, opts)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return buf.String()
+}
+
+func TestJsonToCsv(t *testing.T) {
+
+	require.Equal(t, "id,user.name,user.tags.0,user.tags.1,score\n1,Alice,a,b,NULL\n2,Bob,,,\n",
+		flattenToCsv(t, testNestedJson, files.FlattenOptions{NullValue: "NULL"}))
+
+	require.Equal(t, "id,user_name,user_tags,score\n1,Alice,\"[\"\"a\"\",\"\"b\"\"]\",\n2,Bob,[],\n",
+		flattenToCsv(t, testNestedJson, files.FlattenOptions{Separator: "_", Arrays: files.ArrayJson}))
+
+	require.Equal(t, "id,user.name,user.tags,score\n1,Alice,a,\n1,Alice,b,\n2,Bob,[],\n",
+		flattenToCsv(t, testNestedJson, files.FlattenOptions{Arrays: files.ArrayExplode}))
+
+	// Test Unknown Columns
+	reader, err := files.JsonStream(bytes.NewReader([]byte("{\"id\":1}\n{\"id\":2,\"x\":1}\n")), false)
+	require.NoError(t, err)
+	err = files.JsonToCsv(reader, files.NewCsvStream(ioutil.Discard, false), files.FlattenOptions{SampleSize: 1})
+	require.Error(t, err)
+
+	// no records, no header
+	require.Equal(t, "", flattenToCsv(t, "", files.FlattenOptions{}))
+	require.Equal(t, "", flattenToCsv(t, "", files.FlattenOptions{SampleSize: -1}))
+
+	require.Equal(t, "id,user.name,user.tags.0\n1,Alice,a\n2,Bob,b\n",
+		flattenToCsv(t, "{\"id\":1,\"user\":{\"name\":\"Alice\",\"tags\":[\"a\"]}}\n{\"id\":2,\"user\":{\"name\":\"Bob\",\"tags\":[\"b\",\"c\"]}}\n",
+			files.FlattenOptions{SampleSize: 1, IgnoreUnknown: true}))
+}
+
+func TestCsvToJson(t *testing.T) {
+
+	content := "id,user.name,user.tags.0,user.tags.1,score\n1,Alice,a,b,NULL\n2,Bob,,,\n"
+
+	reader, err := files.OpenCsvStream(bytes.NewReader([]byte(content)), false)
+	require.NoError(t, err)
+
+	header, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, 5, len(header))
+
+	schema := files.NewCsvSchema(header)
+	opts := files.FlattenOptions{NullValue: "NULL", InferTypes: true}
+
+	row, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":1,\"user\":{\"name\":\"Alice\",\"tags\":[\"a\",\"b\"]},\"score\":null}",
+		string(files.UnflattenCsv(header, schema.Record(row).Record(), opts)))
+
+	row, err = reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, "{\"id\":\"2\",\"user\":{\"name\":\"Bob\"}}",
+		string(files.UnflattenCsv(header, row, files.FlattenOptions{})))
+
+	// the record is an object for numeric headers
+	require.Equal(t, "{\"2023\":\"a\",\"2024\":\"b\"}",
+		string(files.UnflattenCsv([]string{"2023", "2024"}, []string{"a", "b"}, files.FlattenOptions{})))
+
+	// an index beyond the columns of the header is a key, missing elements of arrays are null
+	require.Equal(t, "{\"x\":{\"1000000000\":\"a\"},\"y\":[null,\"b\"]}",
+		string(files.UnflattenCsv([]string{"x.1000000000", "y.0", "y.1"}, []string{"a", "", "b"}, files.FlattenOptions{})))
+}
+
+func TestJsonFileToCsvFile(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "json-csv-test")
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+
+	jsonFilePath := filePath + ".json"
+	csvFilePath := filePath + ".csv.gz"
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(csvFilePath)
+
+	err = ioutil.WriteFile(jsonFilePath, []byte(testNestedJson+"{\"id\":3,\"extra\":true}\n"), 0644)
+	require.NoError(t, err)
+
+	err = files.JsonFileToCsvFile(jsonFilePath, csvFilePath, files.FlattenOptions{SampleSize: -1, InferTypes: true})
+	require.NoError(t, err)
+
+	reader, err := files.OpenCsvFile(csvFilePath)
+	require.NoError(t, err)
+
+	file, err := reader.ReadHeader()
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "user.name", "user.tags.0", "user.tags.1", "score", "extra"}, file.Header())
+
+	var buf bytes.Buffer
+	writer := files.NewJsonStream(&buf, false)
+	err = files.CsvToJson(file, writer, files.FlattenOptions{InferTypes: true})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, reader.Close())
+
+	require.Equal(t, "{\"id\":1,\"user\":{\"name\":\"Alice\",\"tags\":[\"a\",\"b\"]}}\n{\"id\":2,\"user\":{\"name\":\"Bob\"}}\n{\"id\":3,\"extra\":true}\n", buf.String())
+
+	// an empty input makes an empty output
+	require.NoError(t, ioutil.WriteFile(jsonFilePath, nil, 0644))
+	require.NoError(t, files.JsonFileToCsvFile(jsonFilePath, csvFilePath, files.FlattenOptions{SampleSize: -1}))
+	count, err := files.CountFile(csvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+}
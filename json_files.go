@@ -6,6 +6,7 @@ package files
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"github.com/pkg/errors"
@@ -14,18 +15,47 @@ import (
 	"strings"
 )
 
+type JsonFormat int
+
+const (
+	JsonLines JsonFormat = iota   // NDJSON, one record per '\n' terminated line
+	JsonSeq                       // RFC 7464 application/json-seq, every record is RS <json> LF
+	JsonPretty                    // concatenated JSON values separated by any whitespace, written indented
+)
+
+const jsonSeqRS = 0x1E
+
+var ErrJsonSeqTruncated = errors.New("truncated json-seq record")
+
+// ErrJsonSeqRS is returned by writers of json-seq records with a raw RS byte, it would split the record on read.
+var ErrJsonSeqRS = errors.New("json-seq record contains RS byte")
+
+func JsonFormatOf(filePath string) JsonFormat {
+	filePath = strings.TrimSuffix(filePath, ".gz")
+	if strings.HasSuffix(filePath, ".json-seq") {
+		return JsonSeq
+	}
+	return JsonLines
+}
+
 type jsonStreamWriter struct {
 	fd    io.Writer
 	fw    *bufio.Writer
 	gzw   *gzip.Writer
 	bw    *bufio.Writer
 	w     io.Writer
+	format JsonFormat
 }
 
 func NewJsonStream(fd io.Writer, gzipEnabled bool) JsonWriter {
+	return NewJsonStreamFormat(fd, gzipEnabled, JsonLines)
+}
+
+func NewJsonStreamFormat(fd io.Writer, gzipEnabled bool, format JsonFormat) JsonWriter {
 
 	t := &jsonStreamWriter{
 		fd:              fd,
+		format:          format,
 	}
 
 	t.fw = bufio.NewWriterSize(t.fd, FileRWBlockSize)
@@ -41,25 +71,24 @@ func NewJsonStream(fd io.Writer, gzipEnabled bool) JsonWriter {
 	return t
 }
 
-func (t *jsonStreamWriter) Close() (err error) {
-	if t.bw != nil {
-		t.bw.Flush()
-	}
-	if t.gzw != nil {
-		t.gzw.Flush()
-		err = t.gzw.Close()
-	}
-	t.fw.Flush()
-	return err
+func (t *jsonStreamWriter) Flush() error {
+	return flushLayers(bufferFlush(t.bw), gzipFlush(t.gzw), t.fw.Flush)
+}
+
+func (t *jsonStreamWriter) Close() error {
+	return closeLayers(bufferFlush(t.bw), gzipClose(t.gzw), t.fw.Flush)
+}
+
+func (t *jsonStreamWriter) Sync() error {
+	return flushLayers(t.Flush, writerSync(t.fd))
 }
 
 func (t *jsonStreamWriter) WriteRaw(message json.RawMessage) error {
-	_, err := t.w.Write(append(message, '\n'))
-	return err
+	return writeJsonRecord(t.w, t.format, message)
 }
 
 func (t *jsonStreamWriter) Write(object interface{}) error {
-	return JsonWrite(t.w, object)
+	return jsonWriteFormat(t.w, t.format, object)
 }
 
 type jsonFileWriter struct {
@@ -68,21 +97,33 @@ type jsonFileWriter struct {
 	gzw   *gzip.Writer
 	bw    *bufio.Writer
 	w    io.Writer
+	format JsonFormat
 }
 
 func NewJsonFile(filePath string) (JsonWriter, error) {
+	return NewJsonFileFormat(filePath, JsonFormatOf(filePath))
+}
 
-	var err error
-	t := new(jsonFileWriter)
+func NewJsonFileFormat(filePath string, format JsonFormat) (JsonWriter, error) {
 
-	t.fd, err = os.Create(filePath)
+	fd, err := createFile(filePath)
 	if err != nil {
 		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
 	}
 
+	return newJsonFileWriter(fd, strings.HasSuffix(filePath, ".gz"), format), nil
+}
+
+func newJsonFileWriter(fd *os.File, gzipEnabled bool, format JsonFormat) *jsonFileWriter {
+
+	t := &jsonFileWriter{
+		fd:     fd,
+		format: format,
+	}
+
 	t.fw = bufio.NewWriterSize(t.fd, FileRWBlockSize)
 
-	if strings.HasSuffix(filePath, ".gz") {
+	if gzipEnabled {
 		t.gzw = gzip.NewWriter(t.fw)
 		t.bw = bufio.NewWriterSize(t.gzw, FileRWBlockSize)
 		t.w = t.bw
@@ -90,31 +131,34 @@ func NewJsonFile(filePath string) (JsonWriter, error) {
 		t.w = t.fw
 	}
 
-	return t, nil
+	return t
+}
+
+func (t *jsonFileWriter) Flush() error {
+	return flushLayers(bufferFlush(t.bw), gzipFlush(t.gzw), t.fw.Flush)
 }
 
 func (t *jsonFileWriter) Close() error {
-	if t.bw != nil {
-		t.bw.Flush()
-	}
-	if t.gzw != nil {
-		t.gzw.Flush()
-		t.gzw.Close()
-	}
-	t.fw.Flush()
-	return t.fd.Close()
+	return closeLayers(bufferFlush(t.bw), gzipClose(t.gzw), t.fw.Flush, syncOnClose(t.fd), t.fd.Close)
+}
+
+func (t *jsonFileWriter) Sync() error {
+	return flushLayers(t.Flush, t.fd.Sync)
 }
 
 func (t *jsonFileWriter) WriteRaw(message json.RawMessage) error {
-	_, err := t.w.Write(append(message, '\n'))
-	return err
+	return writeJsonRecord(t.w, t.format, message)
 }
 
 func (t *jsonFileWriter) Write(object interface{}) error {
-	return JsonWrite(t.w, object)
+	return jsonWriteFormat(t.w, t.format, object)
 }
 
 func JsonWrite(w io.Writer, object interface{}) error {
+	return jsonWriteFormat(w, JsonLines, object)
+}
+
+func jsonWriteFormat(w io.Writer, format JsonFormat, object interface{}) error {
 
 	var jsonBin []byte
 	jsonBin, err := Marshaler.Marshal(object)
@@ -122,7 +166,28 @@ func JsonWrite(w io.Writer, object interface{}) error {
 		return err
 	}
 
-	_, err = w.Write(append(jsonBin, '\n'))
+	return writeJsonRecord(w, format, jsonBin)
+}
+
+func writeJsonRecord(w io.Writer, format JsonFormat, jsonBin []byte) error {
+
+	switch format {
+	case JsonSeq:
+		if bytes.IndexByte(jsonBin, jsonSeqRS) >= 0 {
+			return ErrJsonSeqRS
+		}
+		if _, err := w.Write([]byte{jsonSeqRS}); err != nil {
+			return err
+		}
+	case JsonPretty:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, jsonBin, "", "  "); err != nil {
+			return err
+		}
+		jsonBin = buf.Bytes()
+	}
+
+	_, err := w.Write(append(jsonBin, '\n'))
 	return err
 }
 
@@ -130,10 +195,14 @@ type jsonStreamReader struct {
 	fr   io.Reader
 	gzr   *gzip.Reader
 	r     *bufio.Reader
-	lastErr error
+	s     jsonScanner
 }
 
 func JsonStream(fr io.Reader, gzipEnabled bool) (JsonReader, error) {
+	return JsonStreamFormat(fr, gzipEnabled, JsonLines)
+}
+
+func JsonStreamFormat(fr io.Reader, gzipEnabled bool, format JsonFormat) (JsonReader, error) {
 
 	var err error
 	t := &jsonStreamReader{
@@ -150,6 +219,7 @@ func JsonStream(fr io.Reader, gzipEnabled bool) (JsonReader, error) {
 		t.r = bufio.NewReader(t.fr)
 	}
 
+	t.s = newJsonScanner(t.r, format)
 	return t, nil
 
 }
@@ -162,32 +232,13 @@ func (t *jsonStreamReader) Close() (err error) {
 }
 
 func (t *jsonStreamReader) ReadRaw() (json.RawMessage, error) {
-	if t.lastErr != nil {
-		return nil, t.lastErr
-	}
-	jsonBin, err := t.r.ReadBytes('\n')
-	if len(jsonBin) > 0 {
-		if err == nil {
-			jsonBin = jsonBin[:len(jsonBin)-1]  // remove last '\n'
-		} else if err == io.EOF {
-			t.lastErr, err = err, nil
-		}
-	}
-	return jsonBin, err
+	return t.s.next()
 }
 
 func (t *jsonStreamReader) Read(holder interface{}) error {
-	if t.lastErr != nil {
-		return t.lastErr
-	}
-	jsonBin, err := t.r.ReadBytes('\n')
+	jsonBin, err := t.s.next()
 	if err != nil {
-		if err == io.EOF && len(jsonBin) > 0 {
-			// last item
-			t.lastErr, err = err, nil
-		} else {
-			return err
-		}
+		return err
 	}
 	return Marshaler.Unmarshal(jsonBin, holder)
 }
@@ -197,20 +248,28 @@ type jsonFileReader struct {
 	fr   *bufio.Reader
 	gzr   *gzip.Reader
 	r     *bufio.Reader
-	lastErr error
+	s     jsonScanner
 }
 
 func OpenJsonFile(filePath string) (JsonReader, error) {
+	return OpenJsonFileFormat(filePath, JsonFormatOf(filePath))
+}
+
+func OpenJsonFileFormat(filePath string, format JsonFormat) (JsonReader, error) {
 
 	fd, err := os.Open(filePath)
 	if err != nil {
 		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
 	}
 
-	return JsonFile(fd)
+	return JsonFileFormat(fd, format)
 }
 
 func JsonFile(fd *os.File) (JsonReader, error) {
+	return JsonFileFormat(fd, JsonFormatOf(fd.Name()))
+}
+
+func JsonFileFormat(fd *os.File, format JsonFormat) (JsonReader, error) {
 
 	var err error
 	t := &jsonFileReader{
@@ -229,6 +288,7 @@ func JsonFile(fd *os.File) (JsonReader, error) {
 		t.r = t.fr
 	}
 
+	t.s = newJsonScanner(t.r, format)
 	return t, nil
 
 }
@@ -241,133 +301,136 @@ func (t *jsonFileReader) Close() error {
 }
 
 func (t *jsonFileReader) ReadRaw() (json.RawMessage, error) {
-	if t.lastErr != nil {
-		return nil, t.lastErr
-	}
-	jsonBin, err := t.r.ReadBytes('\n')
-	if len(jsonBin) > 0 {
-		if err == nil {
-			jsonBin = jsonBin[:len(jsonBin)-1]  // remove last '\n'
-		} else if err == io.EOF {
-			t.lastErr, err = err, nil
-		}
-	}
-	return jsonBin, err
+	return t.s.next()
 }
 
 func (t *jsonFileReader) Read(holder interface{}) error {
-	if t.lastErr != nil {
-		return t.lastErr
-	}
-	jsonBin, err := t.r.ReadBytes('\n')
+	jsonBin, err := t.s.next()
 	if err != nil {
-		if err == io.EOF && len(jsonBin) > 0 {
-			// last item
-			t.lastErr, err = err, nil
-		} else {
-			return err
-		}
+		return err
 	}
 	return Marshaler.Unmarshal(jsonBin, holder)
 }
 
-func SplitJsonFile(inputFilePath string, limit int, partFn func (int) string) ([]string, error) {
+type jsonScanner interface {
 
-	reader, err := OpenJsonFile(inputFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-
-	var parts []string
-	var writer JsonWriter
-
-	partNum := 1
-	for cnt := limit; err == nil; cnt++ {
+	next() ([]byte, error)
 
-		raw, err := reader.ReadRaw()
-		if err != nil {
-			break
-		}
-
-		if cnt == limit {
-			if writer != nil {
-				writer.Close()
-				writer = nil
-			}
-			partFilePath := partFn(partNum)
-			writer, err = NewJsonFile(partFilePath)
-			if err != nil {
-				break
-			}
-			parts = append(parts, partFilePath)
-			cnt = 0
-			partNum++
-		}
+}
 
-		err = writer.WriteRaw(raw)
+func newJsonScanner(r *bufio.Reader, format JsonFormat) jsonScanner {
+	switch format {
+	case JsonSeq:
+		return &jsonSeqScanner{r: r}
+	case JsonPretty:
+		return &jsonValueScanner{dec: json.NewDecoder(r)}
+	default:
+		return &jsonLineScanner{r: r}
 	}
+}
 
-	if err == io.EOF {
-		err = nil
-	}
+type jsonLineScanner struct {
+	r       *bufio.Reader
+	lastErr error
+}
 
-	if writer != nil {
-		writer.Close()
+func (t *jsonLineScanner) next() ([]byte, error) {
+	if t.lastErr != nil {
+		return nil, t.lastErr
 	}
-
-	if err != nil {
-		for _, part := range parts {
-			os.Remove(part)
+	jsonBin, err := t.r.ReadBytes('\n')
+	if len(jsonBin) > 0 {
+		if err == nil {
+			jsonBin = jsonBin[:len(jsonBin)-1]  // remove last '\n'
+		} else if err == io.EOF {
+			t.lastErr, err = err, nil
 		}
-		parts = nil
 	}
-
-	return parts, err
+	return jsonBin, err
 }
 
-func JoinJsonFiles(outputFilePath string, parts []string) error {
+// jsonValueScanner uses the streaming tokenizer of encoding/json to find value boundaries,
+// so records may span many lines, the returned bytes are exactly the bytes of the value.
+type jsonValueScanner struct {
+	dec     *json.Decoder
+	lastErr error
+}
 
-	writer, err := NewJsonFile(outputFilePath)
-	if err != nil {
-		return err
+func (t *jsonValueScanner) next() ([]byte, error) {
+	if t.lastErr != nil {
+		return nil, t.lastErr
+	}
+	var raw json.RawMessage
+	if err := t.dec.Decode(&raw); err != nil {
+		t.lastErr = err
+		return nil, err
 	}
-	defer writer.Close()
+	return raw, nil
+}
 
-	for _, part := range parts {
+// jsonSeqScanner reads RFC 7464 sequences. A record that can not be parsed is returned together with
+// ErrJsonSeqTruncated, the scanner stays usable and the next call continues from the following RS.
+type jsonSeqScanner struct {
+	r       *bufio.Reader
+	started bool
+	lastErr error
+}
 
-		reader, err := OpenJsonFile(part)
-		if err != nil {
-			return errors.Errorf("can not open file '%s', %v", part, err)
+func (t *jsonSeqScanner) next() ([]byte, error) {
+	for {
+
+		if t.lastErr != nil {
+			return nil, t.lastErr
 		}
 
-		for {
+		chunk, err := t.r.ReadBytes(jsonSeqRS)
+		if err == nil {
+			chunk = chunk[:len(chunk)-1]  // remove next RS
+		} else if err == io.EOF {
+			t.lastErr = err
+		} else {
+			return nil, err
+		}
 
-			raw, err := reader.ReadRaw()
-			if err != nil {
-				break
-			}
+		jsonBin := bytes.TrimSpace(chunk)
 
-			err = writer.WriteRaw(raw)
-			if err != nil {
-				reader.Close()
-				return errors.Errorf("can not write row to file '%s', %v", outputFilePath, err)
+		if !t.started {
+			t.started = true
+			if len(jsonBin) > 0 {
+				// garbage before the first RS
+				return jsonBin, ErrJsonSeqTruncated
 			}
-
+			continue
 		}
 
-		if err == io.EOF {
-			err = nil
+		if len(jsonBin) == 0 {
+			continue
 		}
 
-		reader.Close()
+		if !json.Valid(jsonBin) {
+			return jsonBin, ErrJsonSeqTruncated
+		}
 
-		if err != nil {
-			return errors.Errorf("join read file '%s', %v", part, err)
+		switch jsonBin[0] {
+		case '{', '[', '"':
+		default:
+			// top-level numbers, true, false and null must be followed by whitespace, otherwise they may be cut
+			if chunk[len(chunk)-1] == jsonBin[len(jsonBin)-1] {
+				return jsonBin, ErrJsonSeqTruncated
+			}
 		}
 
+		return jsonBin, nil
 	}
+}
 
-	return nil
+func SplitJsonFile(inputFilePath string, limit int, partFn func (int) string) ([]string, error) {
+	return SplitJsonFileWith(inputFilePath, SplitOptions{Limit: limit}, partFn)
+}
+
+// JoinJsonFiles joins json files, use JoinJsonFilesWith for the report and the options.
+func JoinJsonFiles(outputFilePath string, parts []string) error {
+	_, err := JoinJsonFilesWith(outputFilePath, parts, JoinOptions{})
+	return err
 }
 
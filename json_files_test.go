@@ -153,9 +153,182 @@ func TestJsonSplit(t *testing.T) {
 
 	require.Equal(t, string(all), string(joined))
 
+	// a failed join removes the partial output
+	err = files.JoinJsonFiles(jsonFilePath, append(parts, filePath+"_missing.json"))
+	require.Error(t, err)
+	_, err = os.Stat(jsonFilePath)
+	require.True(t, os.IsNotExist(err))
+
 	os.Remove(jsonFilePath)
 	for _, part := range parts {
 		println("RemoveFile: ", part)
 		os.Remove(part)
 	}
 }
+
+func TestJsonSeqWriteAndRead(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "json-test")
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+
+	// Test Plain
+	filePath = filePath + ".json-seq"
+	writeJson(t, filePath)
+	var buf bytes.Buffer
+	writeJsonStream(t, files.NewJsonStreamFormat(&buf, false, files.JsonSeq))
+
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), content)
+	require.Equal(t, "\x1e{\"test\":\"obj1\"}\n\x1e{\"test\":\"obj2\"}\n", string(content))
+
+	stream, err := files.JsonStreamFormat(bytes.NewReader(content), false, files.JsonSeq)
+	require.NoError(t, err)
+	readJsonStream(t, stream)
+	readJson(t, filePath)
+
+	os.Remove(filePath)
+
+	// Test GZIP
+	filePath = filePath + ".gz"
+	writeJson(t, filePath)
+	readJson(t, filePath)
+	os.Remove(filePath)
+
+}
+
+func TestJsonSeqRecovery(t *testing.T) {
+
+	content := "\x1e{\"test\":\n  \"obj1\"\n}\n\x1e{\"test\":\"cut\x1e123\x1e\x1e 456\n\x1e{\"test\":\"obj2\"}\n"
+
+	reader, err := files.JsonStreamFormat(bytes.NewReader([]byte(content)), false, files.JsonSeq)
+	require.NoError(t, err)
+
+	raw, err := reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "{\"test\":\n  \"obj1\"\n}", string(raw))
+
+	raw, err = reader.ReadRaw()
+	require.Equal(t, files.ErrJsonSeqTruncated, err)
+	require.Equal(t, "{\"test\":\"cut", string(raw))
+
+	// number without trailing whitespace is truncated
+	raw, err = reader.ReadRaw()
+	require.Equal(t, files.ErrJsonSeqTruncated, err)
+	require.Equal(t, "123", string(raw))
+
+	raw, err = reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "456", string(raw))
+
+	obj := make(map[string]interface{})
+	err = reader.Read(&obj)
+	require.NoError(t, err)
+	require.Equal(t, "obj2", obj["test"])
+
+	_, err = reader.ReadRaw()
+	require.Equal(t, io.EOF, err)
+
+	err = reader.Close()
+	require.NoError(t, err)
+}
+
+func TestJsonSeqRejectsRS(t *testing.T) {
+
+	var buf bytes.Buffer
+	writer := files.NewJsonStreamFormat(&buf, false, files.JsonSeq)
+	require.NoError(t, writer.WriteRaw([]byte("{\"test\":\"obj1\"}")))
+	require.Equal(t, files.ErrJsonSeqRS, writer.WriteRaw([]byte("{\"test\":\"a\x1e{\"b\":1}\"}")))
+	// encoded strings escape RS
+	require.NoError(t, writer.Write(map[string]string{"test": "a\x1eb"}))
+	require.NoError(t, writer.Close())
+
+	reader, err := files.JsonStreamFormat(bytes.NewReader(buf.Bytes()), false, files.JsonSeq)
+	require.NoError(t, err)
+
+	obj := make(map[string]interface{})
+	require.NoError(t, reader.Read(&obj))
+	require.Equal(t, "obj1", obj["test"])
+	require.NoError(t, reader.Read(&obj))
+	require.Equal(t, "a\x1eb", obj["test"])
+
+	_, err = reader.ReadRaw()
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, reader.Close())
+}
+
+func TestJsonPrettyRead(t *testing.T) {
+
+	content := "{\n  \"test\": \"obj1\",\n  \"list\": [1, 2, {\"a\": \"}\"}]\n}{\"test\":\"obj2\"}\n\n  [1,\n2]  \"str\"\n"
+
+	reader, err := files.JsonStreamFormat(bytes.NewReader([]byte(content)), false, files.JsonPretty)
+	require.NoError(t, err)
+
+	raw, err := reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "{\n  \"test\": \"obj1\",\n  \"list\": [1, 2, {\"a\": \"}\"}]\n}", string(raw))
+
+	obj := make(map[string]interface{})
+	err = reader.Read(&obj)
+	require.NoError(t, err)
+	require.Equal(t, "obj2", obj["test"])
+
+	raw, err = reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "[1,\n2]", string(raw))
+
+	raw, err = reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "\"str\"", string(raw))
+
+	_, err = reader.ReadRaw()
+	require.Equal(t, io.EOF, err)
+
+	err = reader.Close()
+	require.NoError(t, err)
+
+	// Test Pretty Writer
+	var buf bytes.Buffer
+	writeJsonStream(t, files.NewJsonStreamFormat(&buf, false, files.JsonPretty))
+	require.Equal(t, "{\n  \"test\": \"obj1\"\n}\n{\n  \"test\": \"obj2\"\n}\n", buf.String())
+
+	stream, err := files.JsonStreamFormat(&buf, false, files.JsonPretty)
+	require.NoError(t, err)
+	readJsonStream(t, stream)
+}
+
+func TestJoinTruncatedParts(t *testing.T) {
+
+	filePath := tempFilePath(t, "json-test")
+
+	for _, ext := range []string{".jsonl.gz", ".json-seq", ".pb.gz"} {
+
+		partPath := filePath + "_part1" + ext
+		outPath := filePath + ext
+		defer os.Remove(partPath)
+		defer os.Remove(outPath)
+
+		writer, err := files.CreateFile(partPath, nil)
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, writer.Write(files.Record{Raw: []byte(fmt.Sprintf(`{"n":%d}`, i))}))
+		}
+		require.NoError(t, writer.Close())
+
+		content, err := ioutil.ReadFile(partPath)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(partPath, content[:len(content)/2+3], 0644))
+
+		if ext == ".pb.gz" {
+			err = files.JoinProtoFiles(outPath, nil, []string{partPath})
+		} else {
+			err = files.JoinJsonFiles(outPath, []string{partPath})
+		}
+		require.Error(t, err, ext)
+		_, err = os.Stat(outPath)
+		require.True(t, os.IsNotExist(err), ext)
+	}
+}
@@ -0,0 +1,387 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+type jsonPointerNode struct {
+	children map[string]*jsonPointerNode
+	pointers []string
+}
+
+type jsonProjector struct {
+	pointers []string
+	root     *jsonPointerNode
+}
+
+func newJsonProjector(pointers []string) (*jsonProjector, error) {
+	t := &jsonProjector{
+		pointers: pointers,
+		root:     &jsonPointerNode{},
+	}
+	for _, pointer := range pointers {
+		tokens, err := parseJsonPointer(pointer)
+		if err != nil {
+			return nil, err
+		}
+		node := t.root
+		for _, token := range tokens {
+			if node.children == nil {
+				node.children = make(map[string]*jsonPointerNode)
+			}
+			child, ok := node.children[token]
+			if !ok {
+				child = &jsonPointerNode{}
+				node.children[token] = child
+			}
+			node = child
+		}
+		node.pointers = append(node.pointers, pointer)
+	}
+	return t, nil
+}
+
+func parseJsonPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errors.Errorf("invalid json pointer '%s'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescapeJsonPointer(token)
+	}
+	return tokens, nil
+}
+
+// ExtractJsonPointers returns the raw values at the given RFC 6901 pointers, pointers that are not found are absent.
+// Subtrees that no pointer refers to are skipped without decoding.
+func ExtractJsonPointers(jsonBin []byte, pointers ...string) (map[string]json.RawMessage, error) {
+	p, err := newJsonProjector(pointers)
+	if err != nil {
+		return nil, err
+	}
+	return p.extract(jsonBin)
+}
+
+func (t *jsonProjector) extract(jsonBin []byte) (map[string]json.RawMessage, error) {
+	m := make(map[string]json.RawMessage, len(t.pointers))
+	s := &jsonSkipper{data: jsonBin}
+	s.skipSpace()
+	if err := s.walk(t.root, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type jsonSkipper struct {
+	data []byte
+	pos  int
+}
+
+func (t *jsonSkipper) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("json syntax error at offset %d, "+format, append([]interface{}{t.pos}, args...)...)
+}
+
+func (t *jsonSkipper) skipSpace() {
+	for t.pos < len(t.data) {
+		switch t.data[t.pos] {
+		case ' ', '\t', '\n', '\r':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (t *jsonSkipper) expect(c byte) error {
+	t.skipSpace()
+	if t.pos >= len(t.data) || t.data[t.pos] != c {
+		return t.errorf("expected '%c'", c)
+	}
+	t.pos++
+	return nil
+}
+
+func (t *jsonSkipper) walk(node *jsonPointerNode, m map[string]json.RawMessage) error {
+
+	t.skipSpace()
+	start := t.pos
+
+	if node.children == nil {
+		if err := t.skipValue(); err != nil {
+			return err
+		}
+	} else if t.pos < len(t.data) && t.data[t.pos] == '{' {
+		if err := t.walkObject(node, m); err != nil {
+			return err
+		}
+	} else if t.pos < len(t.data) && t.data[t.pos] == '[' {
+		if err := t.walkArray(node, m); err != nil {
+			return err
+		}
+	} else if err := t.skipValue(); err != nil {
+		return err
+	}
+
+	for _, pointer := range node.pointers {
+		m[pointer] = t.data[start:t.pos]
+	}
+	return nil
+}
+
+func (t *jsonSkipper) walkObject(node *jsonPointerNode, m map[string]json.RawMessage) error {
+	t.pos++ // '{'
+	t.skipSpace()
+	if t.pos < len(t.data) && t.data[t.pos] == '}' {
+		t.pos++
+		return nil
+	}
+	for {
+		t.skipSpace()
+		keyStart := t.pos
+		if err := t.skipString(); err != nil {
+			return err
+		}
+		key, err := jsonKey(t.data[keyStart:t.pos])
+		if err != nil {
+			return t.errorf("%v", err)
+		}
+		if err := t.expect(':'); err != nil {
+			return err
+		}
+		if child, ok := node.children[key]; ok {
+			err = t.walk(child, m)
+		} else {
+			t.skipSpace()
+			err = t.skipValue()
+		}
+		if err != nil {
+			return err
+		}
+		t.skipSpace()
+		if t.pos >= len(t.data) {
+			return t.errorf("unexpected end of object")
+		}
+		switch t.data[t.pos] {
+		case ',':
+			t.pos++
+		case '}':
+			t.pos++
+			return nil
+		default:
+			return t.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+func (t *jsonSkipper) walkArray(node *jsonPointerNode, m map[string]json.RawMessage) error {
+	t.pos++ // '['
+	t.skipSpace()
+	if t.pos < len(t.data) && t.data[t.pos] == ']' {
+		t.pos++
+		return nil
+	}
+	for i := 0; ; i++ {
+		var err error
+		if child, ok := node.children[strconv.Itoa(i)]; ok {
+			err = t.walk(child, m)
+		} else {
+			t.skipSpace()
+			err = t.skipValue()
+		}
+		if err != nil {
+			return err
+		}
+		t.skipSpace()
+		if t.pos >= len(t.data) {
+			return t.errorf("unexpected end of array")
+		}
+		switch t.data[t.pos] {
+		case ',':
+			t.pos++
+		case ']':
+			t.pos++
+			return nil
+		default:
+			return t.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func jsonKey(quoted []byte) (string, error) {
+	for _, c := range quoted {
+		if c == '\\' {
+			var key string
+			err := json.Unmarshal(quoted, &key)
+			return key, err
+		}
+	}
+	return string(quoted[1 : len(quoted)-1]), nil
+}
+
+func (t *jsonSkipper) skipString() error {
+	if t.pos >= len(t.data) || t.data[t.pos] != '"' {
+		return t.errorf("expected string")
+	}
+	for t.pos++; t.pos < len(t.data); t.pos++ {
+		switch t.data[t.pos] {
+		case '\\':
+			t.pos++
+		case '"':
+			t.pos++
+			return nil
+		}
+	}
+	return t.errorf("unterminated string")
+}
+
+// skipValue moves over the value at the current position by matching brackets outside of strings.
+func (t *jsonSkipper) skipValue() error {
+	if t.pos >= len(t.data) {
+		return t.errorf("unexpected end of input")
+	}
+	switch t.data[t.pos] {
+	case '"':
+		return t.skipString()
+	case '{', '[':
+		depth := 0
+		for t.pos < len(t.data) {
+			switch t.data[t.pos] {
+			case '"':
+				if err := t.skipString(); err != nil {
+					return err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					t.pos++
+					return nil
+				}
+			}
+			t.pos++
+		}
+		return t.errorf("unexpected end of input")
+	default:
+		start := t.pos
+		for t.pos < len(t.data) {
+			switch t.data[t.pos] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				if t.pos == start {
+					return t.errorf("unexpected '%c'", t.data[t.pos])
+				}
+				return nil
+			}
+			t.pos++
+		}
+		if t.pos == start {
+			return t.errorf("unexpected end of input")
+		}
+		return nil
+	}
+}
+
+// JsonValueString converts a raw JSON value to a cell value, strings are unquoted, null is empty.
+func JsonValueString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	switch raw[0] {
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+	case 'n':
+		if string(raw) == "null" {
+			return ""
+		}
+	}
+	return string(raw)
+}
+
+type jsonProjection struct {
+	reader    JsonReader
+	projector *jsonProjector
+	index     map[string]int
+}
+
+// NewJsonProjection reads only the values at the given JSON pointers from every record,
+// the pointers become the header of the returned CsvFile.
+func NewJsonProjection(reader JsonReader, pointers ...string) (CsvFile, error) {
+
+	p, err := newJsonProjector(pointers)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for i, name := range pointers {
+		index[name] = i
+	}
+
+	return &jsonProjection{
+		reader:    reader,
+		projector: p,
+		index:     index,
+	}, nil
+}
+
+func (t *jsonProjection) Header() []string {
+	return t.projector.pointers
+}
+
+func (t *jsonProjection) Index() map[string]int {
+	return t.index
+}
+
+func (t *jsonProjection) Next() (CsvRecord, error) {
+	raw, err := t.reader.ReadRaw()
+	if err != nil {
+		return nil, err
+	}
+	m, err := t.projector.extract(raw)
+	if err != nil {
+		return nil, err
+	}
+	return jsonProjectionRecord{m, t}, nil
+}
+
+type jsonProjectionRecord struct {
+	values     map[string]json.RawMessage
+	projection *jsonProjection
+}
+
+func (t jsonProjectionRecord) Record() []string {
+	list := make([]string, len(t.projection.projector.pointers))
+	for i, pointer := range t.projection.projector.pointers {
+		list[i] = JsonValueString(t.values[pointer])
+	}
+	return list
+}
+
+func (t jsonProjectionRecord) Field(name, def string) string {
+	if raw, ok := t.values[name]; ok {
+		return JsonValueString(raw)
+	}
+	return def
+}
+
+func (t jsonProjectionRecord) Fields() map[string]string {
+	m := make(map[string]string, len(t.values))
+	for pointer, raw := range t.values {
+		m[pointer] = JsonValueString(raw)
+	}
+	return m
+}
@@ -0,0 +1,66 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"testing"
+)
+
+func TestExtractJsonPointers(t *testing.T) {
+
+	content := `{"id": 7, "skip": {"deep": [1, {"x": "]}"}]}, "user": {"name": "Bob", "a/b": true, "tags": ["x", "y"]}, "n": null}`
+
+	m, err := files.ExtractJsonPointers([]byte(content), "/id", "/user/name", "/user/a~1b", "/user/tags/1", "/user/tags", "/n", "/missing", "/skip/deep/5")
+	require.NoError(t, err)
+
+	require.Equal(t, 6, len(m))
+	require.Equal(t, "7", string(m["/id"]))
+	require.Equal(t, `"Bob"`, string(m["/user/name"]))
+	require.Equal(t, "true", string(m["/user/a~1b"]))
+	require.Equal(t, `"y"`, string(m["/user/tags/1"]))
+	require.Equal(t, `["x", "y"]`, string(m["/user/tags"]))
+	require.Equal(t, "null", string(m["/n"]))
+
+	m, err = files.ExtractJsonPointers([]byte(content), "")
+	require.NoError(t, err)
+	require.Equal(t, content, string(m[""]))
+
+	_, err = files.ExtractJsonPointers([]byte(`{"id": 7, "user": {"name": "Bob"`), "/user/name")
+	require.Error(t, err)
+
+	_, err = files.ExtractJsonPointers([]byte(content), "id")
+	require.Error(t, err)
+}
+
+func TestJsonProjection(t *testing.T) {
+
+	content := "{\"id\":1,\"user\":{\"name\":\"Alice\"},\"wide\":[1,2,3]}\n{\"id\":2,\"wide\":{}}\n"
+
+	stream, err := files.JsonStream(bytes.NewReader([]byte(content)), false)
+	require.NoError(t, err)
+
+	file, err := files.NewJsonProjection(stream, "/id", "/user/name")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/id", "/user/name"}, file.Header())
+	require.Equal(t, 1, file.Index()["/user/name"])
+
+	record, err := file.Next()
+	require.NoError(t, err)
+	require.Equal(t, []string{"1", "Alice"}, record.Record())
+	require.Equal(t, "Alice", record.Field("/user/name", "def"))
+	require.Equal(t, map[string]string{"/id": "1", "/user/name": "Alice"}, record.Fields())
+
+	record, err = file.Next()
+	require.NoError(t, err)
+	require.Equal(t, []string{"2", ""}, record.Record())
+	require.Equal(t, "def", record.Field("/user/name", "def"))
+
+	_, err = file.Next()
+	require.Equal(t, io.EOF, err)
+}
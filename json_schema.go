@@ -0,0 +1,602 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JsonSchema is a compiled subset of JSON Schema draft 2020-12: type, enum, const, required, properties,
+// additionalProperties, items, prefixItems, pattern, minLength, maxLength, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, minItems, maxItems and local $ref to "#" or "#/$defs/<name>".
+// Other keywords fail the compilation unless they are annotations, so a schema is never validated partially.
+type JsonSchema struct {
+	reject               bool // boolean schema false
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	required             []string
+	properties           map[string]*JsonSchema
+	additionalProperties *JsonSchema
+	items                *JsonSchema
+	prefixItems          []*JsonSchema
+	pattern              *regexp.Regexp
+	minLength            *int
+	maxLength            *int
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	minItems             *int
+	maxItems             *int
+	ref                  string
+	root                 *JsonSchema
+	defs                 map[string]*JsonSchema
+}
+
+// jsonSchemaAnnotations are the keywords that do not affect validation, "format" is not one of them.
+var jsonSchemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"deprecated":  true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+type JsonSchemaViolation struct {
+	Pointer string // JSON pointer of the failing value, empty for the whole record
+	Message string
+}
+
+func (t JsonSchemaViolation) String() string {
+	return fmt.Sprintf("'%s' %s", t.Pointer, t.Message)
+}
+
+type JsonValidationError struct {
+	Record     int // zero based index of the record in the input
+	Violations []JsonSchemaViolation
+}
+
+func (t *JsonValidationError) Error() string {
+	list := make([]string, len(t.Violations))
+	for i, v := range t.Violations {
+		list[i] = v.String()
+	}
+	return fmt.Sprintf("record %d does not match schema: %s", t.Record, strings.Join(list, "; "))
+}
+
+func LoadJsonSchema(filePath string) (*JsonSchema, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file read error '%s', %v", filePath, err)
+	}
+	schema, err := ParseJsonSchema(content)
+	if err != nil {
+		return nil, errors.Errorf("schema error in '%s', %v", filePath, err)
+	}
+	return schema, nil
+}
+
+func ParseJsonSchema(content []byte) (*JsonSchema, error) {
+	doc, err := decodeJsonNumbers(content)
+	if err != nil {
+		return nil, err
+	}
+	root := &JsonSchema{}
+	if err := root.compile(doc, root, ""); err != nil {
+		return nil, err
+	}
+	if err := root.resolve(map[*JsonSchema]bool{}); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func decodeJsonNumbers(content []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the json value")
+	}
+	return doc, nil
+}
+
+func (t *JsonSchema) compile(doc interface{}, root *JsonSchema, path string) error {
+
+	t.root = root
+
+	switch v := doc.(type) {
+	case bool:
+		t.reject = !v
+		return nil
+	case map[string]interface{}:
+	default:
+		return errors.Errorf("schema at '%s' must be an object or boolean", path)
+	}
+
+	m := doc.(map[string]interface{})
+
+	if defs, ok := m["$defs"]; ok {
+		defsMap, ok := defs.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("'%s/$defs' must be an object", path)
+		}
+		t.defs = make(map[string]*JsonSchema)
+		for name, def := range defsMap {
+			s := &JsonSchema{}
+			if err := s.compile(def, root, path+"/$defs/"+escapeJsonPointer(name)); err != nil {
+				return err
+			}
+			t.defs[name] = s
+		}
+	}
+
+	for key, value := range m {
+		var err error
+		switch key {
+		case "type":
+			switch tv := value.(type) {
+			case string:
+				t.types = []string{tv}
+			case []interface{}:
+				for _, item := range tv {
+					s, ok := item.(string)
+					if !ok {
+						return errors.Errorf("'%s/type' must contain strings", path)
+					}
+					t.types = append(t.types, s)
+				}
+			default:
+				return errors.Errorf("'%s/type' must be a string or array", path)
+			}
+		case "enum":
+			list, ok := value.([]interface{})
+			if !ok {
+				return errors.Errorf("'%s/enum' must be an array", path)
+			}
+			t.enum = list
+		case "const":
+			t.constValue, t.hasConst = value, true
+		case "required":
+			list, ok := value.([]interface{})
+			if !ok {
+				return errors.Errorf("'%s/required' must be an array", path)
+			}
+			for _, item := range list {
+				s, ok := item.(string)
+				if !ok {
+					return errors.Errorf("'%s/required' must contain strings", path)
+				}
+				t.required = append(t.required, s)
+			}
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return errors.Errorf("'%s/properties' must be an object", path)
+			}
+			t.properties = make(map[string]*JsonSchema)
+			for name, prop := range props {
+				s := &JsonSchema{}
+				if err := s.compile(prop, root, path+"/properties/"+escapeJsonPointer(name)); err != nil {
+					return err
+				}
+				t.properties[name] = s
+			}
+		case "additionalProperties":
+			t.additionalProperties = &JsonSchema{}
+			err = t.additionalProperties.compile(value, root, path+"/additionalProperties")
+		case "items":
+			t.items = &JsonSchema{}
+			err = t.items.compile(value, root, path+"/items")
+		case "prefixItems":
+			list, ok := value.([]interface{})
+			if !ok {
+				return errors.Errorf("'%s/prefixItems' must be an array", path)
+			}
+			for i, item := range list {
+				s := &JsonSchema{}
+				if err := s.compile(item, root, path+"/prefixItems/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+				t.prefixItems = append(t.prefixItems, s)
+			}
+		case "pattern":
+			s, ok := value.(string)
+			if !ok {
+				return errors.Errorf("'%s/pattern' must be a string", path)
+			}
+			t.pattern, err = regexp.Compile(s)
+		case "minLength":
+			t.minLength, err = schemaInt(value, path+"/minLength")
+		case "maxLength":
+			t.maxLength, err = schemaInt(value, path+"/maxLength")
+		case "minItems":
+			t.minItems, err = schemaInt(value, path+"/minItems")
+		case "maxItems":
+			t.maxItems, err = schemaInt(value, path+"/maxItems")
+		case "minimum":
+			t.minimum, err = schemaNumber(value, path+"/minimum")
+		case "maximum":
+			t.maximum, err = schemaNumber(value, path+"/maximum")
+		case "exclusiveMinimum":
+			t.exclusiveMinimum, err = schemaNumber(value, path+"/exclusiveMinimum")
+		case "exclusiveMaximum":
+			t.exclusiveMaximum, err = schemaNumber(value, path+"/exclusiveMaximum")
+		case "$ref":
+			s, ok := value.(string)
+			if !ok {
+				return errors.Errorf("'%s/$ref' must be a string", path)
+			}
+			t.ref = s
+		case "$defs":
+		default:
+			if !jsonSchemaAnnotations[key] {
+				return errors.Errorf("unsupported schema keyword '%s/%s'", path, escapeJsonPointer(key))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *JsonSchema) resolve(visited map[*JsonSchema]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+	if t.ref != "" && t.ref != "#" {
+		name := strings.TrimPrefix(t.ref, "#/$defs/")
+		if name == t.ref || t.root.defs[unescapeJsonPointer(name)] == nil {
+			return errors.Errorf("unsupported or unknown $ref '%s'", t.ref)
+		}
+	}
+	// a value is validated against the whole $ref chain, a chain back to itself never ends
+	chain := map[*JsonSchema]bool{t: true}
+	for s := t.refTarget(); s != nil; s = s.refTarget() {
+		if chain[s] {
+			return errors.Errorf("$ref '%s' is a cycle of $refs", t.ref)
+		}
+		chain[s] = true
+	}
+	var children []*JsonSchema
+	for _, s := range t.defs {
+		children = append(children, s)
+	}
+	for _, s := range t.properties {
+		children = append(children, s)
+	}
+	children = append(children, t.prefixItems...)
+	if t.additionalProperties != nil {
+		children = append(children, t.additionalProperties)
+	}
+	if t.items != nil {
+		children = append(children, t.items)
+	}
+	for _, s := range children {
+		if err := s.resolve(visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refTarget is the schema referenced by $ref, nil without $ref.
+func (t *JsonSchema) refTarget() *JsonSchema {
+	switch t.ref {
+	case "":
+		return nil
+	case "#":
+		return t.root
+	default:
+		return t.root.defs[unescapeJsonPointer(strings.TrimPrefix(t.ref, "#/$defs/"))]
+	}
+}
+
+func schemaInt(value interface{}, path string) (*int, error) {
+	if n, ok := value.(json.Number); ok {
+		if i, err := strconv.Atoi(n.String()); err == nil && i >= 0 {
+			return &i, nil
+		}
+	}
+	return nil, errors.Errorf("'%s' must be a non-negative integer", path)
+}
+
+func schemaNumber(value interface{}, path string) (*float64, error) {
+	if n, ok := value.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return &f, nil
+		}
+	}
+	return nil, errors.Errorf("'%s' must be a number", path)
+}
+
+// Validate checks a single JSON value, an empty list means the value is valid.
+func (t *JsonSchema) Validate(jsonBin []byte) []JsonSchemaViolation {
+	doc, err := decodeJsonNumbers(jsonBin)
+	if err != nil {
+		return []JsonSchemaViolation{{Message: fmt.Sprintf("invalid json, %v", err)}}
+	}
+	var list []JsonSchemaViolation
+	t.validate(doc, "", &list)
+	return list
+}
+
+func (t *JsonSchema) validate(value interface{}, pointer string, list *[]JsonSchemaViolation) {
+
+	violation := func(format string, args ...interface{}) {
+		*list = append(*list, JsonSchemaViolation{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if t.reject {
+		violation("is not allowed")
+		return
+	}
+
+	if target := t.refTarget(); target != nil {
+		target.validate(value, pointer, list)
+	}
+
+	if len(t.types) > 0 {
+		matched := false
+		for _, typ := range t.types {
+			if jsonTypeMatches(typ, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			violation("expected type %s, got %s", strings.Join(t.types, " or "), jsonTypeOf(value))
+			return
+		}
+	}
+
+	if t.enum != nil {
+		found := false
+		for _, e := range t.enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			violation("value is not one of the enum values")
+		}
+	}
+
+	if t.hasConst && !jsonEqual(t.constValue, value) {
+		violation("value does not match const")
+	}
+
+	switch v := value.(type) {
+
+	case string:
+		n := utf8.RuneCountInString(v)
+		if t.minLength != nil && n < *t.minLength {
+			violation("length %d is less than %d", n, *t.minLength)
+		}
+		if t.maxLength != nil && n > *t.maxLength {
+			violation("length %d is greater than %d", n, *t.maxLength)
+		}
+		if t.pattern != nil && !t.pattern.MatchString(v) {
+			violation("does not match pattern '%s'", t.pattern.String())
+		}
+
+	case json.Number:
+		f, _ := v.Float64()
+		if t.minimum != nil && f < *t.minimum {
+			violation("%s is less than minimum %v", v, *t.minimum)
+		}
+		if t.maximum != nil && f > *t.maximum {
+			violation("%s is greater than maximum %v", v, *t.maximum)
+		}
+		if t.exclusiveMinimum != nil && f <= *t.exclusiveMinimum {
+			violation("%s is not greater than %v", v, *t.exclusiveMinimum)
+		}
+		if t.exclusiveMaximum != nil && f >= *t.exclusiveMaximum {
+			violation("%s is not less than %v", v, *t.exclusiveMaximum)
+		}
+
+	case map[string]interface{}:
+		for _, name := range t.required {
+			if _, ok := v[name]; !ok {
+				*list = append(*list, JsonSchemaViolation{Pointer: pointer + "/" + escapeJsonPointer(name), Message: "is required"})
+			}
+		}
+		for name, item := range v {
+			itemPointer := pointer + "/" + escapeJsonPointer(name)
+			if s, ok := t.properties[name]; ok {
+				s.validate(item, itemPointer, list)
+			} else if t.additionalProperties != nil {
+				t.additionalProperties.validate(item, itemPointer, list)
+			}
+		}
+
+	case []interface{}:
+		if t.minItems != nil && len(v) < *t.minItems {
+			violation("has %d items, less than %d", len(v), *t.minItems)
+		}
+		if t.maxItems != nil && len(v) > *t.maxItems {
+			violation("has %d items, more than %d", len(v), *t.maxItems)
+		}
+		for i, item := range v {
+			itemPointer := pointer + "/" + strconv.Itoa(i)
+			if i < len(t.prefixItems) {
+				t.prefixItems[i].validate(item, itemPointer, list)
+			} else if t.items != nil {
+				t.items.validate(item, itemPointer, list)
+			}
+		}
+
+	}
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if isJsonInteger(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonTypeMatches(typ string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	return typ == actual || (typ == "number" && actual == "integer")
+}
+
+func isJsonInteger(n json.Number) bool {
+	if _, err := strconv.ParseInt(n.String(), 10, 64); err == nil {
+		return true
+	}
+	f, err := n.Float64()
+	return err == nil && f == float64(int64(f))
+}
+
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if av == bv {
+			return true
+		}
+		af, err1 := av.Float64()
+		bf, err2 := bv.Float64()
+		return err1 == nil && err2 == nil && af == bf
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if w, ok := bv[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func escapeJsonPointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+func unescapeJsonPointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~1", "/", -1), "~0", "~", -1)
+}
+
+// JsonRejectHandler receives records that failed validation, returning an error stops the reader.
+type JsonRejectHandler func(raw json.RawMessage, err *JsonValidationError) error
+
+func JsonRejectWriter(writer JsonWriter) JsonRejectHandler {
+	return func(raw json.RawMessage, err *JsonValidationError) error {
+		return writer.WriteRaw(raw)
+	}
+}
+
+type jsonValidatingReader struct {
+	reader   JsonReader
+	schema   *JsonSchema
+	onReject JsonRejectHandler
+	index    int
+}
+
+// NewValidatingJsonReader validates every record against the schema. Without onReject an invalid record is returned
+// together with *JsonValidationError and reading may continue, otherwise the record is passed to onReject and skipped.
+func NewValidatingJsonReader(reader JsonReader, schema *JsonSchema, onReject JsonRejectHandler) JsonReader {
+	return &jsonValidatingReader{
+		reader:   reader,
+		schema:   schema,
+		onReject: onReject,
+	}
+}
+
+func (t *jsonValidatingReader) Close() error {
+	return t.reader.Close()
+}
+
+func (t *jsonValidatingReader) ReadRaw() (json.RawMessage, error) {
+	for {
+
+		raw, err := t.reader.ReadRaw()
+		if err != nil {
+			return raw, err
+		}
+
+		index := t.index
+		t.index++
+
+		violations := t.schema.Validate(raw)
+		if len(violations) == 0 {
+			return raw, nil
+		}
+
+		verr := &JsonValidationError{Record: index, Violations: violations}
+		if t.onReject == nil {
+			return raw, verr
+		}
+
+		if err := t.onReject(raw, verr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *jsonValidatingReader) Read(holder interface{}) error {
+	raw, err := t.ReadRaw()
+	if err != nil {
+		return err
+	}
+	return Marshaler.Unmarshal(raw, holder)
+}
@@ -0,0 +1,172 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var testJsonSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["name", "count"],
+  "properties": {
+    "name": { "type": "string", "pattern": "^obj[0-9]+$", "maxLength": 8 },
+    "count": { "type": "integer", "minimum": 0, "exclusiveMaximum": 100 },
+    "kind": { "enum": ["a", "b"] },
+    "tags": { "type": "array", "maxItems": 2, "items": { "type": "string" } },
+    "address": { "$ref": "#/$defs/address" }
+  },
+  "additionalProperties": false,
+  "$defs": {
+    "address": {
+      "type": "object",
+      "required": ["city"],
+      "properties": { "city": { "type": "string", "minLength": 1 } }
+    }
+  }
+}`
+
+func TestJsonSchemaValidate(t *testing.T) {
+
+	schema, err := files.ParseJsonSchema([]byte(testJsonSchema))
+	require.NoError(t, err)
+
+	require.Empty(t, schema.Validate([]byte(`{"name":"obj1","count":5,"kind":"a","tags":["x"],"address":{"city":"SF"}}`)))
+
+	violations := schema.Validate([]byte(`{"name":"other","count":100.5,"kind":"c","tags":["x",1,"z"],"address":{"city":""},"extra":true}`))
+
+	pointers := make(map[string]int)
+	for _, v := range violations {
+		pointers[v.Pointer]++
+	}
+
+	require.Equal(t, map[string]int{
+		"/name":         1,
+		"/count":        1,
+		"/kind":         1,
+		"/tags":         1,
+		"/tags/1":       1,
+		"/address/city": 1,
+		"/extra":        1,
+	}, pointers)
+
+	violations = schema.Validate([]byte(`{"count":-1}`))
+	require.Equal(t, 2, len(violations))
+
+	violations = schema.Validate([]byte(`[]`))
+	require.Equal(t, 1, len(violations))
+	require.Equal(t, "", violations[0].Pointer)
+
+	_, err = files.ParseJsonSchema([]byte(`{"$ref": "#/$defs/missing"}`))
+	require.Error(t, err)
+}
+
+func TestJsonSchemaUnsupportedKeywords(t *testing.T) {
+
+	for _, keyword := range []string{"oneOf", "anyOf", "allOf", "not", "if", "format", "uniqueItems", "patternProperties", "$anchor"} {
+		_, err := files.ParseJsonSchema([]byte(`{"type": "object", "properties": {"name": {"` + keyword + `": []}}}`))
+		require.Error(t, err, keyword)
+		require.Contains(t, err.Error(), "/properties/name/"+keyword)
+	}
+
+	schema, err := files.ParseJsonSchema([]byte(`{"title": "record", "description": "d", "$comment": "c", "default": {}, "examples": [{}],
+		"properties": {"name": {"type": "string", "deprecated": true, "readOnly": true}}}`))
+	require.NoError(t, err)
+	require.Empty(t, schema.Validate([]byte(`{"name":"obj1"}`)))
+}
+
+func TestJsonSchemaRefCycles(t *testing.T) {
+
+	for _, doc := range []string{
+		`{"$ref": "#"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+		`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "properties": {"name": {"$ref": "#/$defs/a"}}}`,
+	} {
+		_, err := files.ParseJsonSchema([]byte(doc))
+		require.Error(t, err, doc)
+		require.Contains(t, err.Error(), "cycle", doc)
+	}
+
+	// recursion through a property reads a nested value each time, so it ends
+	schema, err := files.ParseJsonSchema([]byte(`{"type": "object", "properties": {"child": {"$ref": "#"}, "name": {"type": "string"}}}`))
+	require.NoError(t, err)
+	require.Empty(t, schema.Validate([]byte(`{"name":"a","child":{"name":"b","child":{}}}`)))
+	require.Equal(t, 1, len(schema.Validate([]byte(`{"child":{"child":{"name":1}}}`))))
+}
+
+func TestJsonSchemaReader(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "json-schema-test")
+	require.NoError(t, err)
+	_, err = fd.WriteString(testJsonSchema)
+	require.NoError(t, err)
+	fd.Close()
+	defer os.Remove(fd.Name())
+
+	schema, err := files.LoadJsonSchema(fd.Name())
+	require.NoError(t, err)
+
+	content := "{\"name\":\"obj1\",\"count\":1}\n{\"name\":\"obj2\"}\n{\"name\":\"obj3\",\"count\":3}\n"
+
+	// Test Errors
+	stream, err := files.JsonStream(bytes.NewReader([]byte(content)), false)
+	require.NoError(t, err)
+
+	reader := files.NewValidatingJsonReader(stream, schema, nil)
+
+	raw, err := reader.ReadRaw()
+	require.NoError(t, err)
+	require.Equal(t, "{\"name\":\"obj1\",\"count\":1}", string(raw))
+
+	raw, err = reader.ReadRaw()
+	require.Error(t, err)
+	verr, ok := err.(*files.JsonValidationError)
+	require.True(t, ok)
+	require.Equal(t, 1, verr.Record)
+	require.Equal(t, "/count", verr.Violations[0].Pointer)
+	require.Equal(t, "{\"name\":\"obj2\"}", string(raw))
+
+	obj := make(map[string]interface{})
+	err = reader.Read(&obj)
+	require.NoError(t, err)
+	require.Equal(t, "obj3", obj["name"])
+
+	_, err = reader.ReadRaw()
+	require.Equal(t, io.EOF, err)
+
+	// Test Reject Writer
+	stream, err = files.JsonStream(bytes.NewReader([]byte(content)), false)
+	require.NoError(t, err)
+
+	var rejected bytes.Buffer
+	rejectWriter := files.NewJsonStream(&rejected, false)
+	reader = files.NewValidatingJsonReader(stream, schema, files.JsonRejectWriter(rejectWriter))
+
+	var names []string
+	for {
+		raw, err := reader.ReadRaw()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &m))
+		names = append(names, m["name"].(string))
+	}
+
+	require.Equal(t, []string{"obj1", "obj3"}, names)
+	require.NoError(t, rejectWriter.Close())
+	require.Equal(t, "{\"name\":\"obj2\"}\n", rejected.String())
+
+	require.NoError(t, reader.Close())
+}
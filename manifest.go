@@ -0,0 +1,145 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Manifest describes the parts of a split file, Join verifies the parts with it.
+type Manifest struct {
+	Source       string         `json:"source,omitempty"`
+	SourceSha256 string         `json:"source_sha256,omitempty"`
+	SourceFormat string         `json:"source_format,omitempty"`
+	Format       string         `json:"format"` // format name of the parts with ".gz" suffix when compressed, like "csv.gz"
+	Records      int64          `json:"records"`
+	Parts        []ManifestPart `json:"parts"`
+}
+
+type ManifestPart struct {
+	Path     string `json:"path"`
+	Records  int64  `json:"records"`
+	Bytes    int64  `json:"bytes"`
+	Sha256   string `json:"sha256"`
+	FirstKey string `json:"first_key,omitempty"`
+	LastKey  string `json:"last_key,omitempty"`
+}
+
+func formatName(format *Format, gzipEnabled bool) string {
+	if gzipEnabled {
+		return format.Name + ".gz"
+	}
+	return format.Name
+}
+
+func ReadManifest(filePath string) (*Manifest, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file read error '%s', %v", filePath, err)
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, errors.Errorf("invalid manifest '%s', %v", filePath, err)
+	}
+	return m, nil
+}
+
+func WriteManifest(filePath string, m *Manifest) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath, append(content, '\n'), 0644); err != nil {
+		return errors.Errorf("file write error '%s', %v", filePath, err)
+	}
+	return nil
+}
+
+// Paths returns the part paths in join order.
+func (t *Manifest) Paths() []string {
+	list := make([]string, len(t.Parts))
+	for i, part := range t.Parts {
+		list[i] = part.Path
+	}
+	return list
+}
+
+// FileSha256 returns the hex SHA-256 and the size of the file.
+func FileSha256(filePath string) (string, int64, error) {
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+	defer fd.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, fd)
+	if err != nil {
+		return "", 0, errors.Errorf("file read error '%s', %v", filePath, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// VerifyParts checks the size and SHA-256 of every part file.
+func (t *Manifest) VerifyParts() error {
+	return t.VerifyPartFiles(t.Paths())
+}
+
+// VerifyPartFiles checks the size and SHA-256 of the files against the parts of the manifest in order,
+// the files may be copies of the parts in another location.
+func (t *Manifest) VerifyPartFiles(files []string) error {
+	if len(files) != len(t.Parts) {
+		return errors.Errorf("verifying %d parts, manifest has %d", len(files), len(t.Parts))
+	}
+	for i, part := range t.Parts {
+		sum, size, err := FileSha256(files[i])
+		if err != nil {
+			return err
+		}
+		if size != part.Bytes {
+			return errors.Errorf("part '%s' has %d bytes, manifest expects %d", files[i], size, part.Bytes)
+		}
+		if sum != part.Sha256 {
+			return errors.Errorf("part '%s' has sha256 %s, manifest expects %s", files[i], sum, part.Sha256)
+		}
+	}
+	return nil
+}
+
+// verifiedParts returns the parts to join, the paths of the manifest when parts is nil, after verifying the files.
+func (t *Manifest) verifiedParts(parts []string) ([]string, error) {
+	if parts == nil {
+		parts = t.Paths()
+	} else if len(parts) != len(t.Parts) {
+		return nil, errors.Errorf("joining %d parts, manifest has %d", len(parts), len(t.Parts))
+	}
+	if err := t.VerifyPartFiles(parts); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// VerifyCounts checks the records read from every part, counts are in the order of parts.
+func (t *Manifest) VerifyCounts(counts []int64) error {
+	if len(counts) != len(t.Parts) {
+		return errors.Errorf("joined %d parts, manifest has %d", len(counts), len(t.Parts))
+	}
+	var total int64
+	for i, part := range t.Parts {
+		if counts[i] != part.Records {
+			return errors.Errorf("part '%s' has %d records, manifest expects %d", part.Path, counts[i], part.Records)
+		}
+		total += counts[i]
+	}
+	if total != t.Records {
+		return errors.Errorf("joined %d records, manifest expects %d", total, t.Records)
+	}
+	return nil
+}
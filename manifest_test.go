@@ -0,0 +1,144 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSplitAndJoinWithManifest(t *testing.T) {
+
+	filePath := tempFilePath(t, "manifest-test")
+	csvFilePath := filePath + ".csv.gz"
+	manifestPath := filePath + ".manifest.json"
+	joinedFilePath := filePath + "_joined.csv"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(manifestPath)
+	defer os.Remove(joinedFilePath)
+
+	writeRandomCsv(t, csvFilePath, 25)
+
+	opts := files.SplitOptions{Limit: 10, Manifest: manifestPath, Key: files.CsvColumnKey("id")}
+	parts, err := files.SplitCsvFileWith(csvFilePath, opts, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	m, err := files.ReadManifest(manifestPath)
+	require.NoError(t, err)
+	require.Equal(t, csvFilePath, m.Source)
+	require.Equal(t, "csv.gz", m.SourceFormat)
+	require.Equal(t, "csv.gz", m.Format)
+	require.Equal(t, int64(25), m.Records)
+	require.Equal(t, parts, m.Paths())
+
+	sum, _, err := files.FileSha256(csvFilePath)
+	require.NoError(t, err)
+	require.Equal(t, sum, m.SourceSha256)
+
+	require.Equal(t, 3, len(m.Parts))
+	require.Equal(t, "20", m.Parts[2].FirstKey)
+	require.Equal(t, "24", m.Parts[2].LastKey)
+	require.Equal(t, int64(5), m.Parts[2].Records)
+	for _, part := range m.Parts {
+		sum, size, err := files.FileSha256(part.Path)
+		require.NoError(t, err)
+		require.Equal(t, sum, part.Sha256)
+		require.Equal(t, size, part.Bytes)
+	}
+
+	report, err := files.JoinCsvFilesWith(joinedFilePath, nil, files.JoinOptions{Manifest: m})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), report.Records)
+	cnt, err := files.CountFile(joinedFilePath)
+	require.NoError(t, err)
+	require.Equal(t, int64(25), cnt)
+	os.Remove(joinedFilePath)
+
+	// counts are verified after joining
+	m.Parts[1].Records++
+	_, err = files.JoinCsvFilesWith(joinedFilePath, nil, files.JoinOptions{Manifest: m})
+	require.Error(t, err)
+	_, err = os.Stat(joinedFilePath)
+	require.True(t, os.IsNotExist(err))
+	m.Parts[1].Records--
+
+	// checksums are verified before joining
+	content, err := ioutil.ReadFile(parts[1])
+	require.NoError(t, err)
+	content[len(content)/2] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(parts[1], content, 0644))
+
+	_, err = files.JoinCsvFilesWith(joinedFilePath, nil, files.JoinOptions{Manifest: m})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "sha256")
+	_, err = os.Stat(joinedFilePath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestJoinRelocatedPartsWithManifest(t *testing.T) {
+
+	filePath := tempFilePath(t, "manifest-test")
+	csvFilePath := filePath + ".csv"
+	manifestPath := filePath + ".manifest.json"
+	joinedFilePath := filePath + "_joined.csv"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(manifestPath)
+	defer os.Remove(joinedFilePath)
+
+	writeRandomCsv(t, csvFilePath, 25)
+
+	parts, err := files.SplitCsvFileWith(csvFilePath, files.SplitOptions{Limit: 10, Manifest: manifestPath}, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv", filePath, i)
+	})
+	require.NoError(t, err)
+
+	// copies of the parts in another location, the originals stay untouched
+	var copies []string
+	for _, part := range parts {
+		content, err := ioutil.ReadFile(part)
+		require.NoError(t, err)
+		copyPath := part + ".copy.csv"
+		require.NoError(t, ioutil.WriteFile(copyPath, content, 0644))
+		copies = append(copies, copyPath)
+		defer os.Remove(part)
+		defer os.Remove(copyPath)
+	}
+
+	m, err := files.ReadManifest(manifestPath)
+	require.NoError(t, err)
+
+	report, err := files.JoinCsvFilesWith(joinedFilePath, copies, files.JoinOptions{Manifest: m})
+	require.NoError(t, err)
+	require.Equal(t, int64(25), report.Records)
+	os.Remove(joinedFilePath)
+
+	content, err := ioutil.ReadFile(copies[1])
+	require.NoError(t, err)
+	content[len(content)/2] ^= 0xFF
+	require.NoError(t, ioutil.WriteFile(copies[1], content, 0644))
+
+	_, err = files.JoinCsvFilesWith(joinedFilePath, copies, files.JoinOptions{Manifest: m})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), copies[1])
+
+	_, err = files.JoinCsvFilesParallel(context.Background(), joinedFilePath, copies, files.JoinOptions{Manifest: m})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), copies[1])
+
+	_, err = os.Stat(joinedFilePath)
+	require.True(t, os.IsNotExist(err))
+}
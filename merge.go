@@ -0,0 +1,313 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"container/heap"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// RecordComparator orders records by their keys. Key is called once per record, its errors fail the sort
+// or merge, Compare compares two keys like strings.Compare.
+type RecordComparator struct {
+	Key     func(record Record) (interface{}, error)
+	Compare func(a, b interface{}) int
+}
+
+// CompareRecords makes the comparator of a function comparing whole records, the record is its own key.
+func CompareRecords(compare func(a, b Record) int) *RecordComparator {
+	return &RecordComparator{
+		Key: func(record Record) (interface{}, error) {
+			return record, nil
+		},
+		Compare: func(a, b interface{}) int {
+			return compare(a.(Record), b.(Record))
+		},
+	}
+}
+
+// RecordOrder creates the comparator for the input, header is nil for json and proto records.
+type RecordOrder func(header []string) (*RecordComparator, error)
+
+// OrderByKey orders records by the key string ascending, a record without the key fails the sort or merge.
+func OrderByKey(key RecordKey) RecordOrder {
+	return func(header []string) (*RecordComparator, error) {
+		extractor, err := key(header)
+		if err != nil {
+			return nil, err
+		}
+		return &RecordComparator{
+			Key: func(record Record) (interface{}, error) {
+				s, err := extractor(record)
+				if err != nil {
+					return nil, err
+				}
+				return s, nil
+			},
+			Compare: func(a, b interface{}) int {
+				return strings.Compare(a.(string), b.(string))
+			},
+		}, nil
+	}
+}
+
+type DedupMode int
+
+const (
+	// KeepAll writes all records with equal keys
+	KeepAll DedupMode = iota
+	// KeepFirst writes the first of equal records in part order
+	KeepFirst
+	// KeepLast writes the last of equal records in part order
+	KeepLast
+	// MergeEqual combines equal records with the Merge function
+	MergeEqual
+)
+
+type MergeOptions struct {
+	Order RecordOrder
+	Dedup DedupMode
+	Merge func(a, b Record) (Record, error) // for MergeEqual, a precedes b in part order
+}
+
+type MergeReport struct {
+	Records    int64 // records written
+	Duplicates int64 // records dropped or merged by Dedup
+}
+
+type mergeCursor struct {
+	reader RecordReader
+	part   int
+	pos    int64
+	record Record
+	key    interface{} // key of the record, extracted once
+}
+
+type mergeHeap struct {
+	cursors []*mergeCursor
+	cmp     *RecordComparator
+}
+
+func (t *mergeHeap) Len() int {
+	return len(t.cursors)
+}
+
+// Less keeps equal records in part order, so merging is stable.
+func (t *mergeHeap) Less(i, j int) bool {
+	if c := t.cmp.Compare(t.cursors[i].key, t.cursors[j].key); c != 0 {
+		return c < 0
+	}
+	return t.cursors[i].part < t.cursors[j].part
+}
+
+func (t *mergeHeap) Swap(i, j int) {
+	t.cursors[i], t.cursors[j] = t.cursors[j], t.cursors[i]
+}
+
+func (t *mergeHeap) Push(x interface{}) {
+	t.cursors = append(t.cursors, x.(*mergeCursor))
+}
+
+func (t *mergeHeap) Pop() interface{} {
+	n := len(t.cursors)
+	c := t.cursors[n-1]
+	t.cursors = t.cursors[:n-1]
+	return c
+}
+
+// next reads the next record of the cursor and validates the order, returns false at the end of the part.
+func (t *mergeCursor) next(cmp *RecordComparator) (bool, error) {
+	record, err := t.reader.Read()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	t.pos++
+	key, err := cmp.Key(record)
+	if err != nil {
+		return false, errors.Errorf("key of record %d in part %d, %v", t.pos, t.part+1, err)
+	}
+	if t.pos > 1 && cmp.Compare(t.key, key) > 0 {
+		return false, errors.Errorf("part %d is not sorted at record %d", t.part+1, t.pos)
+	}
+	t.record, t.key = record, key
+	return true, nil
+}
+
+// MergeRecords writes the records of sorted readers in the global order by k-way merge.
+// Keys are extracted once per record, the first key error stops the merge.
+func MergeRecords(writer RecordWriter, readers []RecordReader, cmp *RecordComparator, opts MergeOptions) (*MergeReport, error) {
+
+	if opts.Dedup == MergeEqual && opts.Merge == nil {
+		return nil, errors.New("merge function is not set")
+	}
+
+	h := &mergeHeap{cmp: cmp}
+	for i, reader := range readers {
+		c := &mergeCursor{reader: reader, part: i}
+		ok, err := c.next(cmp)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			h.cursors = append(h.cursors, c)
+		}
+	}
+	heap.Init(h)
+
+	report := new(MergeReport)
+	var pending Record
+	var pendingKey interface{}
+	hasPending := false
+
+	for h.Len() > 0 {
+
+		c := h.cursors[0]
+		record, key := c.record, c.key
+
+		ok, err := c.next(cmp)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+
+		if opts.Dedup == KeepAll {
+			if err := writer.Write(record); err != nil {
+				return nil, err
+			}
+			report.Records++
+			continue
+		}
+
+		if hasPending && cmp.Compare(pendingKey, key) == 0 {
+			report.Duplicates++
+			switch opts.Dedup {
+			case KeepLast:
+				pending = record
+			case MergeEqual:
+				if pending, err = opts.Merge(pending, record); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if hasPending {
+			if err := writer.Write(pending); err != nil {
+				return nil, err
+			}
+			report.Records++
+		}
+		pending, pendingKey, hasPending = record, key, true
+	}
+
+	if hasPending {
+		if err := writer.Write(pending); err != nil {
+			return nil, err
+		}
+		report.Records++
+	}
+
+	return report, nil
+}
+
+func joinSortedKindFiles(ctx context.Context, kind string, outputFilePath string, parts []string, opts MergeOptions) (report *MergeReport, err error) {
+
+	if opts.Order == nil {
+		return nil, errors.New("record order is not set")
+	}
+
+	format, gzipEnabled, err := kindFileFormat(kind, outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var readers []RecordReader
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+
+	for _, part := range parts {
+		reader, err := openKindFile(kind, part)
+		if err != nil {
+			return nil, errors.Errorf("can not open file '%s', %v", part, err)
+		}
+		readers = append(readers, WithContextReader(ctx, reader))
+		if !sameHeader(readers[0].Header(), reader.Header()) {
+			return nil, errors.Errorf("header of file '%s' does not match the header of '%s'", part, parts[0])
+		}
+	}
+
+	var header []string
+	if len(readers) > 0 {
+		header = readers[0].Header()
+	}
+
+	cmp, err := opts.Order(header)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := format.CreateFile(outputFilePath, gzipEnabled, header)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err = MergeRecords(writer, readers, cmp, opts)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+		return nil, err
+	}
+	return report, nil
+}
+
+// JoinSortedFiles merges parts sorted by the order of options into the sorted output, formats are selected by extension.
+func JoinSortedFiles(outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(context.Background(), "", outputFilePath, parts, opts)
+}
+
+func JoinSortedCsvFiles(outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(context.Background(), CsvRecords, outputFilePath, parts, opts)
+}
+
+func JoinSortedJsonFiles(outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(context.Background(), JsonRecords, outputFilePath, parts, opts)
+}
+
+func JoinSortedProtoFiles(outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(context.Background(), ProtoRecords, outputFilePath, parts, opts)
+}
+
+// JoinSortedFilesContext merges like JoinSortedFiles and stops with ctx.Err() once the context is done, the output is removed.
+func JoinSortedFilesContext(ctx context.Context, outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(ctx, "", outputFilePath, parts, opts)
+}
+
+func JoinSortedCsvFilesContext(ctx context.Context, outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(ctx, CsvRecords, outputFilePath, parts, opts)
+}
+
+func JoinSortedJsonFilesContext(ctx context.Context, outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(ctx, JsonRecords, outputFilePath, parts, opts)
+}
+
+func JoinSortedProtoFilesContext(ctx context.Context, outputFilePath string, parts []string, opts MergeOptions) (*MergeReport, error) {
+	return joinSortedKindFiles(ctx, ProtoRecords, outputFilePath, parts, opts)
+}
@@ -0,0 +1,87 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"os"
+	"testing"
+)
+
+func TestJoinSortedCsvFiles(t *testing.T) {
+
+	filePath := tempFilePath(t, "merge-test")
+	p1, p2, p3 := filePath+"_1.csv", filePath+"_2.csv.gz", filePath+"_3.csv"
+	outputFilePath := filePath + "_merged.csv"
+	for _, path := range []string{p1, p2, p3, outputFilePath} {
+		defer os.Remove(path)
+	}
+
+	header := []string{"key", "part"}
+	writeCsvPart(t, p1, header, []string{"a", "1"}, []string{"c", "1"}, []string{"e", "1"})
+	writeCsvPart(t, p2, header, []string{"b", "2"}, []string{"c", "2"}, []string{"f", "2"})
+	writeCsvPart(t, p3, header)
+
+	parts := []string{p1, p2, p3}
+	order := files.OrderByKey(files.CsvColumnKey("key"))
+
+	report, err := files.JoinSortedCsvFiles(outputFilePath, parts, files.MergeOptions{Order: order})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), report.Records)
+	require.Equal(t, [][]string{header, {"a", "1"}, {"b", "2"}, {"c", "1"}, {"c", "2"}, {"e", "1"}, {"f", "2"}}, readCsvRows(t, outputFilePath))
+
+	report, err = files.JoinSortedCsvFiles(outputFilePath, parts, files.MergeOptions{Order: order, Dedup: files.KeepFirst})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), report.Records)
+	require.Equal(t, int64(1), report.Duplicates)
+	require.Equal(t, []string{"c", "1"}, readCsvRows(t, outputFilePath)[3])
+
+	report, err = files.JoinSortedCsvFiles(outputFilePath, parts, files.MergeOptions{Order: order, Dedup: files.KeepLast})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "2"}, readCsvRows(t, outputFilePath)[3])
+
+	merge := func(a, b files.Record) (files.Record, error) {
+		return files.Record{Values: []string{a.Values[0], a.Values[1] + "+" + b.Values[1]}}, nil
+	}
+	_, err = files.JoinSortedCsvFiles(outputFilePath, parts, files.MergeOptions{Order: order, Dedup: files.MergeEqual, Merge: merge})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "1+2"}, readCsvRows(t, outputFilePath)[3])
+
+	writeCsvPart(t, p3, header, []string{"d", "3"}, []string{"b", "3"})
+	_, err = files.JoinSortedCsvFiles(outputFilePath, parts, files.MergeOptions{Order: order})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not sorted")
+	_, err = os.Stat(outputFilePath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestJoinSortedKeyErrors(t *testing.T) {
+
+	filePath := tempFilePath(t, "merge-test")
+	p1, p2 := filePath+"_1.csv", filePath+"_2.csv"
+	outputFilePath := filePath + "_merged.csv"
+	for _, path := range []string{p1, p2, outputFilePath} {
+		defer os.Remove(path)
+	}
+
+	header := []string{"key"}
+	writeCsvPart(t, p1, header, []string{"a"}, []string{"c"}, []string{"e"})
+	writeCsvPart(t, p2, header, []string{"b"}, []string{"d"})
+
+	// keys are extracted once per record, not on every heap comparison
+	var count int
+	report, err := files.JoinSortedCsvFiles(outputFilePath, []string{p1, p2}, files.MergeOptions{Order: files.OrderByKey(countingKey("key", &count))})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), report.Records)
+	require.Equal(t, 5, count)
+
+	writeCsvPart(t, p2, header, []string{"b"}, []string{"bad"})
+	_, err = files.JoinSortedCsvFiles(outputFilePath, []string{p1, p2}, files.MergeOptions{Order: files.OrderByKey(countingKey("key", &count))})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "key of record 2 in part 2")
+	_, err = os.Stat(outputFilePath)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,169 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+const (
+	MetricRecordsRead            = "records_read"
+	MetricBytesRead              = "bytes_read"            // uncompressed
+	MetricCompressedBytesRead    = "compressed_bytes_read" // as stored, equal to bytes for plain files
+	MetricReadErrors             = "read_errors"
+	MetricRecordsWritten         = "records_written"
+	MetricBytesWritten           = "bytes_written"
+	MetricCompressedBytesWritten = "compressed_bytes_written"
+	MetricWriteErrors            = "write_errors"
+	MetricFlushSeconds           = "flush_seconds"
+	MetricCloseSeconds           = "close_seconds"
+	MetricCompressionRatio       = "compression_ratio" // uncompressed to compressed bytes of each closed gzip file
+)
+
+// MetricsCounter is satisfied by prometheus.Counter.
+type MetricsCounter interface {
+	Add(v float64)
+}
+
+// MetricsObserver is satisfied by prometheus.Observer, like a histogram or summary.
+type MetricsObserver interface {
+	Observe(v float64)
+}
+
+// Metrics resolves the metrics of readers and writers by name and format name, like "csv.gz".
+// A Prometheus adapter returns counterVec.WithLabelValues(format) and histogramVec.WithLabelValues(format).
+// Metrics are resolved once per file, they must be safe for concurrent use.
+// Only the record readers and writers of OpenFileWith and CreateFileWith report metrics.
+type Metrics interface {
+	Counter(name string, format string) MetricsCounter
+
+	Observer(name string, format string) MetricsObserver
+}
+
+type expvarMetrics struct {
+	m *expvar.Map
+}
+
+// NewExpvarMetrics keeps the metrics in the map by "name.format" keys, observers keep "_count" and "_sum" keys.
+func NewExpvarMetrics(m *expvar.Map) Metrics {
+	return &expvarMetrics{m}
+}
+
+var defaultExpvarMetrics struct {
+	sync.Once
+	metrics Metrics
+}
+
+// ExpvarMetrics returns the metrics published by expvar as "files".
+func ExpvarMetrics() Metrics {
+	defaultExpvarMetrics.Do(func() {
+		m, ok := expvar.Get("files").(*expvar.Map)
+		if !ok {
+			m = expvar.NewMap("files")
+		}
+		defaultExpvarMetrics.metrics = NewExpvarMetrics(m)
+	})
+	return defaultExpvarMetrics.metrics
+}
+
+type expvarCounter struct {
+	m   *expvar.Map
+	key string
+}
+
+func (t *expvarCounter) Add(v float64) {
+	t.m.Add(t.key, int64(v))
+}
+
+type expvarObserver struct {
+	m   *expvar.Map
+	key string
+}
+
+func (t *expvarObserver) Observe(v float64) {
+	t.m.Add(t.key+"_count", 1)
+	t.m.AddFloat(t.key+"_sum", v)
+}
+
+func (t *expvarMetrics) Counter(name string, format string) MetricsCounter {
+	return &expvarCounter{t.m, name + "." + format}
+}
+
+func (t *expvarMetrics) Observer(name string, format string) MetricsObserver {
+	return &expvarObserver{t.m, name + "." + format}
+}
+
+// fileMetrics are the metrics of a reader or writer resolved for its direction and format.
+// The typed readers and writers of NewCsvFile, NewJsonFile, NewProtoFile and their Open counterparts are not instrumented.
+type fileMetrics struct {
+	records         MetricsCounter
+	bytes           MetricsCounter
+	compressedBytes MetricsCounter
+	errors          MetricsCounter
+	flush           MetricsObserver
+	close           MetricsObserver
+	ratio           MetricsObserver
+}
+
+func newReadMetrics(m Metrics, format string) *fileMetrics {
+	if m == nil {
+		return nil
+	}
+	return &fileMetrics{
+		records:         m.Counter(MetricRecordsRead, format),
+		bytes:           m.Counter(MetricBytesRead, format),
+		compressedBytes: m.Counter(MetricCompressedBytesRead, format),
+		errors:          m.Counter(MetricReadErrors, format),
+		close:           m.Observer(MetricCloseSeconds, format),
+		ratio:           m.Observer(MetricCompressionRatio, format),
+	}
+}
+
+func newWriteMetrics(m Metrics, format string) *fileMetrics {
+	if m == nil {
+		return nil
+	}
+	return &fileMetrics{
+		records:         m.Counter(MetricRecordsWritten, format),
+		bytes:           m.Counter(MetricBytesWritten, format),
+		compressedBytes: m.Counter(MetricCompressedBytesWritten, format),
+		errors:          m.Counter(MetricWriteErrors, format),
+		flush:           m.Observer(MetricFlushSeconds, format),
+		close:           m.Observer(MetricCloseSeconds, format),
+		ratio:           m.Observer(MetricCompressionRatio, format),
+	}
+}
+
+func (t *fileMetrics) add(records, bytes, compressedBytes int64) {
+	if records != 0 {
+		t.records.Add(float64(records))
+	}
+	if bytes != 0 {
+		t.bytes.Add(float64(bytes))
+	}
+	if compressedBytes != 0 {
+		t.compressedBytes.Add(float64(compressedBytes))
+	}
+}
+
+func (t *fileMetrics) observeError(err error) {
+	if err != nil {
+		t.errors.Add(1)
+	}
+}
+
+func (t *fileMetrics) observeLatency(o MetricsObserver, start time.Time) {
+	if o != nil {
+		o.Observe(time.Since(start).Seconds())
+	}
+}
+
+func (t *fileMetrics) observeRatio(p Progress) {
+	if p.CompressedBytes > 0 {
+		t.ratio.Observe(float64(p.Bytes) / float64(p.CompressedBytes))
+	}
+}
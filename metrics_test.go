@@ -0,0 +1,167 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"expvar"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type testMetrics struct {
+	sync.Mutex
+	values map[string]float64
+	counts map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{values: make(map[string]float64), counts: make(map[string]int)}
+}
+
+type testMetric struct {
+	m   *testMetrics
+	key string
+}
+
+func (t *testMetric) Add(v float64) {
+	t.m.Lock()
+	t.m.values[t.key] += v
+	t.m.Unlock()
+}
+
+func (t *testMetric) Observe(v float64) {
+	t.m.Lock()
+	t.m.values[t.key] += v
+	t.m.counts[t.key]++
+	t.m.Unlock()
+}
+
+func (t *testMetrics) Counter(name string, format string) files.MetricsCounter {
+	return &testMetric{t, name + "." + format}
+}
+
+func (t *testMetrics) Observer(name string, format string) files.MetricsObserver {
+	return &testMetric{t, name + "." + format}
+}
+
+func TestFileMetrics(t *testing.T) {
+
+	filePath := tempFilePath(t, "metrics-test") + ".csv.gz"
+	defer os.Remove(filePath)
+
+	m := newTestMetrics()
+
+	writer, err := files.CreateFileWith(filePath, []string{"name", "count"}, files.FileOptions{Metrics: m})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Flush())
+	require.Error(t, writer.Write(files.Record{Raw: []byte("{}")}))
+	require.NoError(t, writer.Close())
+
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	require.Equal(t, 100.0, m.values["records_written.csv.gz"])
+	require.Equal(t, float64(fi.Size()), m.values["compressed_bytes_written.csv.gz"])
+	require.True(t, m.values["bytes_written.csv.gz"] > m.values["compressed_bytes_written.csv.gz"])
+	require.Equal(t, 1.0, m.values["write_errors.csv.gz"])
+	require.Equal(t, 1, m.counts["flush_seconds.csv.gz"])
+	require.Equal(t, 1, m.counts["close_seconds.csv.gz"])
+	require.Equal(t, 1, m.counts["compression_ratio.csv.gz"])
+	require.InDelta(t, m.values["bytes_written.csv.gz"]/m.values["compressed_bytes_written.csv.gz"], m.values["compression_ratio.csv.gz"], 1e-9)
+
+	reader, err := files.OpenFileWith(filePath, files.FileOptions{Metrics: m})
+	require.NoError(t, err)
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	require.NoError(t, reader.Close())
+
+	require.Equal(t, 100.0, m.values["records_read.csv.gz"])
+	require.Equal(t, float64(fi.Size()), m.values["compressed_bytes_read.csv.gz"])
+	require.Equal(t, m.values["bytes_written.csv.gz"], m.values["bytes_read.csv.gz"])
+	require.Equal(t, 0.0, m.values["read_errors.csv.gz"])
+	require.Equal(t, 2, m.counts["close_seconds.csv.gz"])
+}
+
+func TestExpvarMetrics(t *testing.T) {
+
+	filePath := tempFilePath(t, "metrics-test") + ".jsonl"
+	defer os.Remove(filePath)
+
+	writer, err := files.CreateFileWith(filePath, nil, files.FileOptions{Metrics: files.ExpvarMetrics()})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(files.Record{Raw: []byte(`{"a":1}`)}))
+	require.NoError(t, writer.Close())
+
+	m := expvar.Get("files").(*expvar.Map)
+	require.Equal(t, "1", m.Get("records_written.jsonl").String())
+	require.Equal(t, "8", m.Get("bytes_written.jsonl").String())
+	require.Equal(t, "8", m.Get("compressed_bytes_written.jsonl").String())
+	require.Equal(t, "1", m.Get("close_seconds.jsonl_count").String())
+	require.Nil(t, m.Get("compression_ratio.jsonl_count"))
+	require.Equal(t, files.ExpvarMetrics(), files.ExpvarMetrics())
+}
+
+func TestFileMetricsGzipBytes(t *testing.T) {
+
+	for _, ext := range []string{".csv.gz", ".jsonl.gz", ".pb.gz"} {
+
+		filePath := tempFilePath(t, "metrics-test") + ext
+		defer os.Remove(filePath)
+
+		format := ext[1:] // the format name with the ".gz" suffix
+		m := newTestMetrics()
+
+		writer, err := files.CreateFileWith(filePath, []string{"name"}, files.FileOptions{Metrics: m})
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			record := files.Record{Values: []string{fmt.Sprintf("name%d", i)}, Raw: []byte(fmt.Sprintf(`{"name":"name%d"}`, i))}
+			require.NoError(t, writer.Write(record))
+		}
+		require.NoError(t, writer.Close())
+
+		content, err := ioutil.ReadFile(filePath)
+		require.NoError(t, err)
+		gzr, err := gzip.NewReader(bytes.NewReader(content))
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(gzr)
+		require.NoError(t, err)
+
+		require.Equal(t, float64(len(content)), m.values["compressed_bytes_written."+format], ext)
+		require.Equal(t, float64(len(data)), m.values["bytes_written."+format], ext)
+
+		reader, err := files.OpenFileWith(filePath, files.FileOptions{Metrics: m})
+		require.NoError(t, err)
+		for {
+			_, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+		}
+		require.NoError(t, reader.Close())
+
+		require.Equal(t, 1000.0, m.values["records_read."+format], ext)
+		require.Equal(t, float64(len(content)), m.values["compressed_bytes_read."+format], ext)
+		require.Equal(t, float64(len(data)), m.values["bytes_read."+format], ext)
+		require.Equal(t, 2, m.counts["compression_ratio."+format], ext)
+	}
+}
@@ -0,0 +1,534 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const (
+	parallelBatchRecords = 1024
+	parallelBatchBytes   = 1 << 20
+)
+
+// encodeJob encodes a batch of records into a standalone chunk, gzip chunks are members of a multi-member gzip file.
+type encodeJob struct {
+	part    *partWriter // part of the split, nil for the join
+	first   bool        // first chunk of the file, writes the csv header
+	records []Record
+	format  *Format
+	gzip    bool
+	header  []string
+	out     bytes.Buffer
+	err     error
+	done    chan struct{}
+}
+
+func (t *encodeJob) encode() {
+	defer close(t.done)
+	var header []string
+	if t.first {
+		header = t.header
+	}
+	w, err := t.format.Create(&t.out, t.gzip, header)
+	if err != nil {
+		t.err = err
+		return
+	}
+	for _, record := range t.records {
+		if err := w.Write(record); err != nil {
+			w.Close()
+			t.err = err
+			return
+		}
+	}
+	t.err = w.Close()
+}
+
+// encodePipeline encodes jobs by a bounded number of workers and delivers them in the submission order.
+type encodePipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan *encodeJob
+	queue  chan *encodeJob
+	wg     sync.WaitGroup
+}
+
+func workersOf(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+func newEncodePipeline(ctx context.Context, workers int) *encodePipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &encodePipeline{
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(chan *encodeJob),
+		queue:  make(chan *encodeJob, workers*2),
+	}
+	for i := 0; i < workers; i++ {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			for job := range t.jobs {
+				job.encode()
+			}
+		}()
+	}
+	return t
+}
+
+func (t *encodePipeline) submit(job *encodeJob) error {
+	job.done = make(chan struct{})
+	select {
+	case t.queue <- job:
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+	select {
+	case t.jobs <- job:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+// drain runs the writer of encoded jobs in the submission order until the queue is closed.
+func (t *encodePipeline) drain(write func(job *encodeJob) error) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		err := func() error {
+			for job := range t.queue {
+				select {
+				case <-job.done:
+				case <-t.ctx.Done():
+					return t.ctx.Err()
+				}
+				if job.err != nil {
+					return job.err
+				}
+				if err := write(job); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+		if err != nil {
+			t.cancel()
+		}
+		result <- err
+	}()
+	return result
+}
+
+// close stops the workers and returns the error of the writer, the producer error goes first.
+func (t *encodePipeline) close(err error, result <-chan error) error {
+	if err != nil {
+		t.cancel()
+	}
+	close(t.queue)
+	close(t.jobs)
+	t.wg.Wait()
+	writeErr := <-result
+	t.cancel()
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// splitParallel cuts the records into parts while the workers encode batches and the writer appends them to the parts.
+// MaxBytes is checked like by partWriter.fits: the encoded size of the part is awaited once the estimate
+// with the uncompressed size of the batches in flight overflows, so the parts are of the same size as by Split.
+func (t *splitter) splitParallel(ctx context.Context, reader RecordReader) error {
+
+	header := reader.Header()
+	p := newEncodePipeline(ctx, workersOf(t.opts.Workers))
+
+	var current *partWriter
+	result := p.drain(func(job *encodeJob) error {
+		if job.part != current {
+			if current != nil {
+				if err := current.Close(); err != nil {
+					return err
+				}
+			}
+			current = job.part
+		}
+		_, err := current.counter.Write(job.out.Bytes())
+		return err
+	})
+
+	var part *partWriter
+	var batch []Record
+	var batchBytes int64
+	var encoded, pending int64 // encoded size of the finished batches of the part, uncompressed size of the others
+	var inflight []*encodeJob  // batches of the part submitted after the last settle
+	first := false
+
+	flush := func() error {
+		job := &encodeJob{part: part, first: first, records: batch, format: part.recordFormat, gzip: part.gzipEnabled, header: header}
+		batch, batchBytes, first = nil, 0, false
+		pending += splitReserve
+		inflight = append(inflight, job)
+		return p.submit(job)
+	}
+
+	// settle waits for the batches in flight, the encoded size of the part becomes exact
+	settle := func() error {
+		for _, job := range inflight {
+			select {
+			case <-job.done:
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+			if job.err != nil {
+				return job.err
+			}
+			encoded += int64(job.out.Len())
+		}
+		inflight, pending = nil, 0
+		return nil
+	}
+
+	fits := func(size int64) (bool, error) {
+		if encoded+pending+pending/1000+size+splitReserve <= t.opts.MaxBytes {
+			return true, nil
+		}
+		if len(batch) > 0 {
+			if err := flush(); err != nil {
+				return false, err
+			}
+		}
+		if err := settle(); err != nil {
+			return false, err
+		}
+		return encoded+size+size/1000+splitReserve <= t.opts.MaxBytes, nil
+	}
+
+	err := func() error {
+		for {
+			if err := p.ctx.Err(); err != nil {
+				return err
+			}
+
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			size := recordSize(record)
+			roll := part == nil || (t.opts.Limit > 0 && part.Records >= int64(t.opts.Limit))
+			if !roll && t.opts.MaxBytes > 0 && part.Records > 0 {
+				fits, err := fits(size)
+				if err != nil {
+					return err
+				}
+				roll = !fits
+			}
+
+			if roll {
+				if len(batch) > 0 {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				if part, err = openPart(t.target, len(t.parts)+1); err != nil {
+					return err
+				}
+				if t.opts.Manifest != "" {
+					part.enableChecksum()
+				}
+				t.parts = append(t.parts, part)
+				encoded, pending, inflight, first = 0, 0, nil, true
+				if header != nil {
+					pending += recordSize(Record{Values: header})
+				}
+			}
+
+			batch = append(batch, record)
+			batchBytes += size
+			pending += size
+			if part.Records == 0 {
+				part.first = record
+			}
+			part.last = record
+			part.Records++
+
+			if len(batch) >= parallelBatchRecords || batchBytes >= parallelBatchBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if len(batch) > 0 {
+			return flush()
+		}
+		return nil
+	}()
+
+	err = p.close(err, result)
+	if current != nil {
+		if closeErr := current.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// SplitFileParallel splits like SplitFileWith, reading, encoding and writing of the parts overlap in goroutines.
+// Gzip parts consist of a gzip member per batch of records, all parts are removed on error or cancellation.
+func SplitFileParallel(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile("", inputFilePath, opts, partFn, func(s *splitter, reader RecordReader) error {
+		return s.splitParallel(ctx, reader)
+	})
+}
+
+func SplitCsvFileParallel(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(CsvRecords, inputFilePath, opts, partFn, func(s *splitter, reader RecordReader) error {
+		return s.splitParallel(ctx, reader)
+	})
+}
+
+func SplitJsonFileParallel(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(JsonRecords, inputFilePath, opts, partFn, func(s *splitter, reader RecordReader) error {
+		return s.splitParallel(ctx, reader)
+	})
+}
+
+func SplitProtoFileParallel(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(ProtoRecords, inputFilePath, opts, partFn, func(s *splitter, reader RecordReader) error {
+		return s.splitParallel(ctx, reader)
+	})
+}
+
+// partFeed reads a part ahead of the join in its own goroutine.
+type partFeed struct {
+	header  []string
+	batches chan []Record
+	ready   chan struct{} // closed when the header is read or opening failed
+	err     error         // valid after batches or ready without header are closed
+}
+
+func (t *partFeed) run(ctx context.Context, kind string, part string, tracker *progressTracker) {
+
+	defer close(t.batches)
+
+	reader, err := openProgressKindFile(kind, part, tracker)
+	if err != nil {
+		t.err = errors.Errorf("can not open file '%s', %v", part, err)
+		close(t.ready)
+		return
+	}
+	defer reader.Close()
+
+	t.header = reader.Header()
+	close(t.ready)
+
+	var batch []Record
+	for {
+		if err := contextErr(ctx); err != nil {
+			t.err = err // the join failed or was cancelled, stop reading the part
+			return
+		}
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.err = errors.Errorf("join file '%s', %v", part, err)
+			return
+		}
+		batch = append(batch, record)
+		if len(batch) == parallelBatchRecords {
+			select {
+			case t.batches <- batch:
+			case <-ctx.Done():
+				t.err = ctx.Err()
+				return
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		select {
+		case t.batches <- batch:
+		case <-ctx.Done():
+			t.err = ctx.Err()
+		}
+	}
+}
+
+func joinParallelKindFiles(ctx context.Context, kind string, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+
+	format, gzipEnabled, err := kindFileFormat(kind, outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Manifest != nil {
+		if parts, err = opts.Manifest.verifiedParts(parts); err != nil {
+			return nil, err
+		}
+	}
+
+	report := new(JoinReport)
+	if opts.Headers == HeaderUnion {
+		if report.Header, err = unionHeader(kind, parts); err != nil {
+			return nil, err
+		}
+	}
+
+	// the output is created before the parts are read, no parts make an empty file
+	fd, err := createFile(outputFilePath)
+	if err != nil {
+		return nil, errors.Errorf("file create error '%s', %v", outputFilePath, err)
+	}
+	output := &bufferedFile{bufio.NewWriterSize(fd, FileRWBlockSize), fd}
+
+	workers := workersOf(opts.Workers)
+	p := newEncodePipeline(ctx, workers)
+	tracker := newFilesProgressTracker(opts.Progress, parts)
+
+	// feeds are started in part order, so the parts being joined always have a reader,
+	// the pipeline context stops them on error and the join returns after all of them
+	feeds := make([]*partFeed, len(parts))
+	for i := range feeds {
+		feeds[i] = &partFeed{batches: make(chan []Record, 2), ready: make(chan struct{})}
+	}
+	var running sync.WaitGroup
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		sem := make(chan struct{}, workers)
+		for i, feed := range feeds {
+			select {
+			case sem <- struct{}{}:
+			case <-p.ctx.Done():
+				for _, feed := range feeds[i:] {
+					feed.err = p.ctx.Err()
+					close(feed.ready)
+					close(feed.batches)
+				}
+				return
+			}
+			running.Add(1)
+			go func(feed *partFeed, part string) {
+				defer running.Done()
+				defer func() { <-sem }()
+				feed.run(p.ctx, kind, part, tracker)
+			}(feed, parts[i])
+		}
+	}()
+
+	result := p.drain(func(job *encodeJob) error {
+		_, err := output.Write(job.out.Bytes())
+		return err
+	})
+
+	counts := make([]int64, 0, len(parts))
+
+	err = func() error {
+		if len(parts) == 0 {
+			return p.submit(&encodeJob{first: true, format: format, gzip: gzipEnabled, header: report.Header})
+		}
+		for i, feed := range feeds {
+
+			select {
+			case <-feed.ready:
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+			if feed.header == nil && feed.err != nil {
+				return feed.err
+			}
+
+			if i == 0 {
+				if opts.Headers != HeaderUnion {
+					report.Header = feed.header
+				}
+				// the first chunk holds the header and makes an empty output a valid gzip file
+				if err := p.submit(&encodeJob{first: true, format: format, gzip: gzipEnabled, header: report.Header}); err != nil {
+					return err
+				}
+			}
+
+			partReport := JoinPartReport{Path: parts[i]}
+			mapping, err := reconcileHeader(report.Header, feed.header, opts, &partReport)
+			if err != nil {
+				return errors.Errorf("header of file '%s', %v", parts[i], err)
+			}
+
+			m := &columnMapper{index: mapping, null: opts.NullValue}
+			for batch := range feed.batches {
+				if mapping != nil {
+					for j, record := range batch {
+						batch[j] = m.mapRecord(record)
+					}
+				}
+				partReport.Records += int64(len(batch))
+				if err := p.submit(&encodeJob{records: batch, format: format, gzip: gzipEnabled}); err != nil {
+					return err
+				}
+			}
+			if feed.err != nil {
+				return feed.err
+			}
+
+			counts = append(counts, partReport.Records)
+			report.Parts = append(report.Parts, partReport)
+			report.Records += partReport.Records
+		}
+		return nil
+	}()
+
+	err = p.close(err, result)
+	running.Wait()
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && opts.Manifest != nil {
+		err = opts.Manifest.VerifyCounts(counts)
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+		return nil, err
+	}
+	if tracker != nil {
+		tracker.finish()
+	}
+	return report, nil
+}
+
+// JoinFilesParallel joins like JoinFilesWith, parts are read ahead and the output is encoded by batches in goroutines.
+// Gzip output consists of a gzip member per batch of records, the output is removed on error or cancellation.
+func JoinFilesParallel(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinParallelKindFiles(ctx, "", outputFilePath, parts, opts)
+}
+
+func JoinCsvFilesParallel(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinParallelKindFiles(ctx, CsvRecords, outputFilePath, parts, opts)
+}
+
+func JoinJsonFilesParallel(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinParallelKindFiles(ctx, JsonRecords, outputFilePath, parts, opts)
+}
+
+func JoinProtoFilesParallel(ctx context.Context, outputFilePath string, parts []string, opts JoinOptions) (*JoinReport, error) {
+	return joinParallelKindFiles(ctx, ProtoRecords, outputFilePath, parts, opts)
+}
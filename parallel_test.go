@@ -0,0 +1,159 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSplitAndJoinParallel(t *testing.T) {
+
+	filePath := tempFilePath(t, "parallel-test")
+	inputFilePath := filePath + ".csv.gz"
+	joinedFilePath := filePath + "_joined.csv.gz"
+	defer os.Remove(inputFilePath)
+	defer os.Remove(joinedFilePath)
+
+	writeRandomCsv(t, inputFilePath, 10000)
+
+	opts := files.SplitOptions{Limit: 3000, MaxBytes: 512 * 1024, Workers: 4}
+	parts, err := files.SplitCsvFileParallel(context.Background(), inputFilePath, opts, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+	require.Equal(t, 4, len(parts))
+
+	var total int64
+	for i, part := range parts {
+		fi, err := os.Stat(part)
+		require.NoError(t, err)
+		require.True(t, fi.Size() <= opts.MaxBytes)
+		rows := readCsvRows(t, part)
+		require.Equal(t, []string{"id", "payload"}, rows[0])
+		require.Equal(t, strconv.Itoa(i*3000), rows[1][0])
+		total += int64(len(rows) - 1)
+	}
+	require.Equal(t, int64(10000), total)
+
+	report, err := files.JoinCsvFilesParallel(context.Background(), joinedFilePath, parts, files.JoinOptions{Workers: 3})
+	require.NoError(t, err)
+	require.Equal(t, int64(10000), report.Records)
+
+	expected := readCsvRows(t, inputFilePath)
+	require.Equal(t, expected, readCsvRows(t, joinedFilePath))
+}
+
+func TestSplitParallelMaxBytesCompressed(t *testing.T) {
+
+	filePath := tempFilePath(t, "parallel-test")
+	inputFilePath := filePath + ".csv.gz"
+	defer os.Remove(inputFilePath)
+
+	writeRandomCsv(t, inputFilePath, 20000)
+
+	opts := files.SplitOptions{MaxBytes: 100000, Workers: 4}
+	parts, err := files.SplitFileWith(inputFilePath, opts, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	parallelParts, err := files.SplitFileParallel(context.Background(), inputFilePath, opts, func(i int) string {
+		return fmt.Sprintf("%s_parallel%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	defer func() {
+		for _, part := range parallelParts {
+			os.Remove(part)
+		}
+	}()
+
+	// the compressed size is limited, not the uncompressed one
+	require.True(t, len(parts) < 15, "%d parts", len(parts))
+	require.True(t, len(parallelParts) >= len(parts) && len(parallelParts) <= len(parts)+1,
+		"%d parallel parts, %d parts", len(parallelParts), len(parts))
+
+	var total int
+	for _, part := range parallelParts {
+		fi, err := os.Stat(part)
+		require.NoError(t, err)
+		require.True(t, fi.Size() <= opts.MaxBytes, "%s size %d", part, fi.Size())
+		total += len(readCsvRows(t, part)) - 1
+	}
+	require.Equal(t, 20000, total)
+}
+
+func TestSplitParallelCancel(t *testing.T) {
+
+	dir := filepath.Dir(tempFilePath(t, "parallel-test"))
+	filePath := tempFilePath(t, "parallel-test")
+	inputFilePath := filePath + ".csv"
+	defer os.Remove(inputFilePath)
+	writeRandomCsv(t, inputFilePath, 5000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := files.SplitCsvFileParallel(ctx, inputFilePath, files.SplitOptions{Limit: 100}, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv", filePath, i)
+	})
+	require.Equal(t, context.Canceled, err)
+
+	matches, err := filepath.Glob(filepath.Join(dir, filepath.Base(filePath)+"_part*"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	_, err = files.JoinCsvFilesParallel(ctx, filePath+"_joined.csv", []string{inputFilePath}, files.JoinOptions{})
+	require.Equal(t, context.Canceled, err)
+	_, err = os.Stat(filePath + "_joined.csv")
+	require.True(t, os.IsNotExist(err))
+}
+
+func openFiles(t *testing.T) int {
+	list, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files are not listed in /proc")
+	}
+	return len(list)
+}
+
+func TestJoinParallelStopsFeedsOnError(t *testing.T) {
+
+	filePath := tempFilePath(t, "parallel-test")
+	partPath := filePath + "_part.csv"
+	joinedFilePath := filePath + "_joined.csv"
+	defer os.Remove(partPath)
+	writeRandomCsv(t, partPath, 20000)
+
+	// the second part is missing, the other feeds are reading ahead when the join fails
+	parts := []string{partPath, filePath + "_missing.csv", partPath, partPath, partPath}
+
+	for i := 0; i < 20; i++ {
+		before := openFiles(t)
+		_, err := files.JoinCsvFilesParallel(context.Background(), joinedFilePath, parts, files.JoinOptions{Workers: 4})
+		require.Error(t, err)
+		// the feeds have closed their parts before the join returned
+		require.Equal(t, before, openFiles(t))
+		_, err = os.Stat(joinedFilePath)
+		require.True(t, os.IsNotExist(err))
+	}
+}
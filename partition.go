@@ -0,0 +1,211 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"hash/fnv"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const partitionBatchSize = 256
+
+// PartitionOf returns the part number in [1, n] of the key, the hash is stable across runs and platforms.
+func PartitionOf(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%uint32(n)) + 1
+}
+
+type partitionWorker struct {
+	part    *partWriter
+	batches chan []Record
+	err     error
+}
+
+func (t *partitionWorker) run(failed *int32, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for batch := range t.batches {
+		if t.err != nil {
+			continue
+		}
+		for _, record := range batch {
+			if err := t.part.Write(record); err != nil {
+				t.err = err
+				atomic.StoreInt32(failed, 1)
+				break
+			}
+		}
+	}
+	if err := t.part.Close(); t.err == nil {
+		t.err = err
+	}
+}
+
+type partitioner struct {
+	n       int
+	key     RecordKey
+	target  partTarget
+	workers []*partitionWorker
+}
+
+// split routes the records by key hash to n parts, every part has its own writer goroutine.
+func (t *partitioner) split(reader RecordReader) error {
+
+	if t.n < 1 {
+		return errors.Errorf("invalid number of partitions %d", t.n)
+	}
+
+	header := reader.Header()
+	extractor, err := t.key(header)
+	if err != nil {
+		return err
+	}
+
+	var failed int32
+	var wg sync.WaitGroup
+
+	for partNum := 1; partNum <= t.n; partNum++ {
+		part, err := newPartWriter(t.target, partNum, header)
+		if err != nil {
+			t.stop(&wg)
+			return err
+		}
+		worker := &partitionWorker{part: part, batches: make(chan []Record, 4)}
+		t.workers = append(t.workers, worker)
+		wg.Add(1)
+		go worker.run(&failed, &wg)
+	}
+
+	batches := make([][]Record, t.n)
+
+	err = func() error {
+		for atomic.LoadInt32(&failed) == 0 {
+
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			key, err := extractor(record)
+			if err != nil {
+				return err
+			}
+
+			i := PartitionOf(key, t.n) - 1
+			batches[i] = append(batches[i], record)
+			if len(batches[i]) == partitionBatchSize {
+				t.workers[i].batches <- batches[i]
+				batches[i] = nil
+			}
+		}
+		return nil
+	}()
+
+	if err == nil {
+		for i, batch := range batches {
+			if len(batch) > 0 {
+				t.workers[i].batches <- batch
+			}
+		}
+	}
+
+	t.stop(&wg)
+
+	if err != nil {
+		return err
+	}
+	for _, worker := range t.workers {
+		if worker.err != nil {
+			return worker.err
+		}
+	}
+	return nil
+}
+
+func (t *partitioner) stop(wg *sync.WaitGroup) {
+	for _, worker := range t.workers {
+		close(worker.batches)
+	}
+	wg.Wait()
+}
+
+func (t *partitioner) parts() []SplitPart {
+	list := make([]SplitPart, len(t.workers))
+	for i, worker := range t.workers {
+		list[i] = worker.part.SplitPart
+	}
+	return list
+}
+
+func (t *partitioner) removeParts() {
+	for _, worker := range t.workers {
+		removePart(worker.part)
+	}
+}
+
+func splitKindFileByKey(ctx context.Context, kind string, inputFilePath string, key RecordKey, n int, partFn func(int) string) ([]SplitPart, error) {
+
+	reader, err := openKindFile(kind, inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	p := &partitioner{
+		n:      n,
+		key:    key,
+		target: filePartTarget(kind, partFn),
+	}
+
+	if err := p.split(WithContextReader(ctx, reader)); err != nil {
+		p.removeParts()
+		return nil, err
+	}
+
+	return p.parts(), nil
+}
+
+// SplitFileByKey splits any supported file into n parts, the records with the same key go to the same part.
+// All n parts are created, parts without records hold only the csv header.
+func SplitFileByKey(inputFilePath string, key RecordKey, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(context.Background(), "", inputFilePath, key, n, partFn)
+}
+
+func SplitCsvFileByKey(inputFilePath string, column string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(context.Background(), CsvRecords, inputFilePath, CsvColumnKey(column), n, partFn)
+}
+
+func SplitJsonFileByKey(inputFilePath string, pointer string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(context.Background(), JsonRecords, inputFilePath, JsonPointerKey(pointer), n, partFn)
+}
+
+func SplitProtoFileByKey(inputFilePath string, md protoreflect.MessageDescriptor, fieldPath string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(context.Background(), ProtoRecords, inputFilePath, ProtoFieldKey(md, fieldPath), n, partFn)
+}
+
+// SplitFileByKeyContext splits like SplitFileByKey and stops with ctx.Err() once the context is done, the parts are removed.
+func SplitFileByKeyContext(ctx context.Context, inputFilePath string, key RecordKey, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(ctx, "", inputFilePath, key, n, partFn)
+}
+
+func SplitCsvFileByKeyContext(ctx context.Context, inputFilePath string, column string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(ctx, CsvRecords, inputFilePath, CsvColumnKey(column), n, partFn)
+}
+
+func SplitJsonFileByKeyContext(ctx context.Context, inputFilePath string, pointer string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(ctx, JsonRecords, inputFilePath, JsonPointerKey(pointer), n, partFn)
+}
+
+func SplitProtoFileByKeyContext(ctx context.Context, inputFilePath string, md protoreflect.MessageDescriptor, fieldPath string, n int, partFn func(int) string) ([]SplitPart, error) {
+	return splitKindFileByKey(ctx, ProtoRecords, inputFilePath, ProtoFieldKey(md, fieldPath), n, partFn)
+}
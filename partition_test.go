@@ -0,0 +1,149 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"os"
+	"testing"
+)
+
+// readPartKeys returns the keys of all records in the part and checks that they belong to the part.
+func readPartKeys(t *testing.T, part files.SplitPart, n int, key files.RecordKey) []string {
+
+	reader, err := files.OpenFile(part.Path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	extractor, err := key(reader.Header())
+	require.NoError(t, err)
+
+	var keys []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		k, err := extractor(record)
+		require.NoError(t, err)
+		require.Equal(t, part.Num, files.PartitionOf(k, n), k)
+		keys = append(keys, k)
+	}
+	require.Equal(t, part.Records, int64(len(keys)))
+	return keys
+}
+
+func TestSplitCsvFileByKey(t *testing.T) {
+
+	filePath := tempFilePath(t, "partition-test")
+	csvFilePath := filePath + ".csv.gz"
+	defer os.Remove(csvFilePath)
+
+	writer, err := files.CreateFile(csvFilePath, []string{"id", "user"})
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprint(i), fmt.Sprintf("user%d", i%37)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	parts, err := files.SplitCsvFileByKey(csvFilePath, "user", 4, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(parts))
+
+	seen := make(map[string]int)
+	var total int64
+	for _, part := range parts {
+		for _, k := range readPartKeys(t, part, 4, files.CsvColumnKey("user")) {
+			seen[k]++
+		}
+		total += part.Records
+		os.Remove(part.Path)
+	}
+	require.Equal(t, int64(1000), total)
+	require.Equal(t, 37, len(seen))
+
+	_, err = files.SplitCsvFileByKey(csvFilePath, "unknown", 4, func(i int) string {
+		return fmt.Sprintf("%s_unknown%d.csv", filePath, i)
+	})
+	require.Error(t, err)
+	_, err = os.Stat(filePath + "_unknown1.csv")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSplitJsonFileByKey(t *testing.T) {
+
+	filePath := tempFilePath(t, "partition-test")
+	jsonFilePath := filePath + ".json"
+	defer os.Remove(jsonFilePath)
+
+	writer, err := files.CreateFile(jsonFilePath, nil)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Raw: []byte(fmt.Sprintf(`{"id":%d,"owner":{"name":"n%d"}}`, i, i%5))}))
+	}
+	require.NoError(t, writer.Close())
+
+	parts, err := files.SplitJsonFileByKey(jsonFilePath, "/owner/name", 3, func(i int) string {
+		return fmt.Sprintf("%s_part%d.json", filePath, i)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(parts))
+
+	var total int64
+	for _, part := range parts {
+		readPartKeys(t, part, 3, files.JsonPointerKey("/owner/name"))
+		total += part.Records
+		os.Remove(part.Path)
+	}
+	require.Equal(t, int64(100), total)
+}
+
+func TestSplitProtoFileByKey(t *testing.T) {
+
+	filePath := tempFilePath(t, "partition-test")
+	protoFilePath := filePath + ".pb"
+	defer os.Remove(protoFilePath)
+
+	writer, err := files.NewProtoFile(protoFilePath)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		domain := &Domain{Domain: fmt.Sprintf("www%d.example.com", i)}
+		if i%2 == 0 {
+			domain.Certificates = &Certificates{Domain: fmt.Sprintf("zone%d.com", i%4)}
+		}
+		_, err = writer.Write(domain)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	md := proto.MessageV2(&Domain{}).ProtoReflect().Descriptor()
+	key := files.ProtoFieldKey(md, "certificates.domain")
+
+	parts, err := files.SplitProtoFileByKey(protoFilePath, md, "certificates.domain", 2, func(i int) string {
+		return fmt.Sprintf("%s_part%d.pb", filePath, i)
+	})
+	require.NoError(t, err)
+
+	seen := make(map[string]int)
+	for _, part := range parts {
+		for _, k := range readPartKeys(t, part, 2, key) {
+			seen[k]++
+		}
+		os.Remove(part.Path)
+	}
+	require.Equal(t, map[string]int{"": 25, "zone0.com": 13, "zone2.com": 12}, seen)
+
+	_, err = files.ProtoFieldKey(md, "certificates")(nil)
+	require.Error(t, err)
+	_, err = files.ProtoFieldKey(md, "unknown")(nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,276 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HiveDefaultPartition is the directory value of empty partition values.
+const HiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+type ReopenMode int
+
+const (
+	// ReopenNewPart writes the records of a reopened partition to the next part file
+	ReopenNewPart ReopenMode = iota
+	// ReopenAppend appends to the last part file of the partition, gzip files get a new member
+	ReopenAppend
+)
+
+type PartitionedWriterOptions struct {
+	Dir          string
+	Columns      []string    // partition names in directory order, like "date" and "country"
+	Keys         []RecordKey // partition value of each column
+	Extension    string      // extension of part files selecting the format, like ".csv.gz"
+	Header       []string    // csv header written to each part file
+	MaxOpenFiles int         // least recently used files are closed above the cap, 0 for 64
+	Reopen       ReopenMode
+	PartPattern  string      // part file name without extension, empty for "part-%04d"
+}
+
+// PartitionFile is a part file written by the PartitionedWriter.
+type PartitionFile struct {
+	Partition string // like "date=2026-10-17/country=US"
+	Path      string
+	Records   int64
+}
+
+type partitionState struct {
+	dir     string
+	partNum int
+	file    *PartitionFile
+	writer  RecordWriter
+	elem    *list.Element
+}
+
+// PartitionedWriter routes records to Hive-style partition directories, like "date=2026-10-17/country=US/part-0001.csv.gz".
+type PartitionedWriter struct {
+	opts        PartitionedWriterOptions
+	format      *Format
+	gzipEnabled bool
+	extractors  []KeyExtractor
+	partitions  map[string]*partitionState
+	lru         *list.List
+	files       []*PartitionFile
+}
+
+func NewPartitionedWriter(opts PartitionedWriterOptions) (*PartitionedWriter, error) {
+
+	if len(opts.Columns) == 0 || len(opts.Columns) != len(opts.Keys) {
+		return nil, errors.Errorf("partition columns %v do not match %d keys", opts.Columns, len(opts.Keys))
+	}
+
+	format, gzipEnabled, err := FormatOf("part" + opts.Extension)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MaxOpenFiles <= 0 {
+		opts.MaxOpenFiles = 64
+	}
+	if opts.PartPattern == "" {
+		opts.PartPattern = "part-%04d"
+	}
+
+	t := &PartitionedWriter{
+		opts:        opts,
+		format:      format,
+		gzipEnabled: gzipEnabled,
+		partitions:  make(map[string]*partitionState),
+		lru:         list.New(),
+	}
+
+	for _, key := range opts.Keys {
+		extractor, err := key(opts.Header)
+		if err != nil {
+			return nil, err
+		}
+		t.extractors = append(t.extractors, extractor)
+	}
+
+	return t, nil
+}
+
+// EscapePartitionValue escapes the characters that are not allowed in Hive partition directories.
+func EscapePartitionValue(value string) string {
+	if value == "" {
+		return HiveDefaultPartition
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7F || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (t *PartitionedWriter) partitionOf(record Record) (string, error) {
+	dirs := make([]string, len(t.extractors))
+	for i, extractor := range t.extractors {
+		value, err := extractor(record)
+		if err != nil {
+			return "", err
+		}
+		dirs[i] = EscapePartitionValue(t.opts.Columns[i]) + "=" + EscapePartitionValue(value)
+	}
+	return strings.Join(dirs, "/"), nil
+}
+
+func (t *PartitionedWriter) Write(record Record) error {
+
+	partition, err := t.partitionOf(record)
+	if err != nil {
+		return err
+	}
+
+	state, ok := t.partitions[partition]
+	if !ok {
+		state = &partitionState{dir: partition}
+		t.partitions[partition] = state
+	}
+
+	if state.writer == nil {
+		if err := t.open(state); err != nil {
+			return err
+		}
+	} else {
+		t.lru.MoveToFront(state.elem)
+	}
+
+	if err := state.writer.Write(record); err != nil {
+		return err
+	}
+	state.file.Records++
+	return nil
+}
+
+// WriteValues writes the csv record.
+func (t *PartitionedWriter) WriteValues(values ...string) error {
+	return t.Write(Record{Values: values})
+}
+
+// WriteRaw writes the JSON text of json records or the message bytes of proto records.
+func (t *PartitionedWriter) WriteRaw(raw []byte) error {
+	return t.Write(Record{Raw: raw})
+}
+
+// WriteMessage marshals the message to the kind of records of the part files.
+func (t *PartitionedWriter) WriteMessage(message proto.Message) error {
+	var raw []byte
+	var err error
+	switch t.format.Kind {
+	case ProtoRecords:
+		raw, err = proto.Marshal(message)
+	case JsonRecords:
+		raw, err = Marshaler.Marshal(message)
+	default:
+		return errors.Errorf("can not write proto message to %s records", t.format.Name)
+	}
+	if err != nil {
+		return errors.Errorf("marshal error, %v", err)
+	}
+	return t.WriteRaw(raw)
+}
+
+func (t *PartitionedWriter) open(state *partitionState) error {
+
+	for t.lru.Len() >= t.opts.MaxOpenFiles {
+		if err := t.closePartition(t.lru.Back().Value.(*partitionState)); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Join(t.opts.Dir, filepath.FromSlash(state.dir))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Errorf("can not create directory '%s', %v", dir, err)
+	}
+
+	var err error
+	if state.file != nil && t.opts.Reopen == ReopenAppend {
+		state.writer, err = t.appendFile(state.file.Path)
+	} else {
+		state.partNum++
+		partFilePath := filepath.Join(dir, fmt.Sprintf(t.opts.PartPattern, state.partNum)+t.opts.Extension)
+		state.writer, err = t.format.CreateFile(partFilePath, t.gzipEnabled, t.opts.Header)
+		if err == nil {
+			state.file = &PartitionFile{Partition: state.dir, Path: partFilePath}
+			t.files = append(t.files, state.file)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	state.elem = t.lru.PushFront(state)
+	return nil
+}
+
+// appendFile continues the part file without the header, gzip readers read concatenated members as one stream.
+func (t *PartitionedWriter) appendFile(filePath string) (RecordWriter, error) {
+
+	fd, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+
+	fw := bufio.NewWriterSize(fd, FileRWBlockSize)
+
+	writer, err := t.format.Create(fw, t.gzipEnabled, nil)
+	if err != nil {
+		fd.Close()
+		return nil, errors.Errorf("append %s records in '%s', %v", t.format.Name, filePath, err)
+	}
+
+	return &fileRecordWriter{writer, fw, fd}, nil
+}
+
+func (t *PartitionedWriter) closePartition(state *partitionState) error {
+	t.lru.Remove(state.elem)
+	state.elem = nil
+	writer := state.writer
+	state.writer = nil
+	return writer.Close()
+}
+
+// Flush flushes all open part files.
+func (t *PartitionedWriter) Flush() error {
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		if err := e.Value.(*partitionState).writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all open part files and returns the first error.
+func (t *PartitionedWriter) Close() (err error) {
+	for t.lru.Len() > 0 {
+		if closeErr := t.closePartition(t.lru.Front().Value.(*partitionState)); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Files lists the written part files in creation order.
+func (t *PartitionedWriter) Files() []PartitionFile {
+	list := make([]PartitionFile, len(t.files))
+	for i, file := range t.files {
+		list[i] = *file
+	}
+	return list
+}
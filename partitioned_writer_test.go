@@ -0,0 +1,94 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePartitioned(t *testing.T, opts files.PartitionedWriterOptions) []files.PartitionFile {
+
+	writer, err := files.NewPartitionedWriter(opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 60; i++ {
+		country := []string{"US", "DE", "FR"}[i%3]
+		require.NoError(t, writer.WriteValues(fmt.Sprintf("2026-10-%02d", 16+i%2), country, fmt.Sprint(i)))
+	}
+	require.NoError(t, writer.Close())
+	return writer.Files()
+}
+
+func TestPartitionedWriter(t *testing.T) {
+
+	dir, err := ioutil.TempDir(os.TempDir(), "partitioned-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := files.PartitionedWriterOptions{
+		Dir:          dir,
+		Columns:      []string{"date", "country"},
+		Keys:         []files.RecordKey{files.CsvColumnKey("date"), files.CsvColumnKey("country")},
+		Extension:    ".csv.gz",
+		Header:       []string{"date", "country", "id"},
+		MaxOpenFiles: 2,
+	}
+
+	list := writePartitioned(t, opts)
+
+	// every record changes the partition, so each record goes to a new part file above the cap
+	require.Equal(t, 60, len(list))
+	require.Equal(t, "date=2026-10-16/country=US", list[0].Partition)
+	require.Equal(t, filepath.Join(dir, "date=2026-10-16", "country=US", "part-0001.csv.gz"), list[0].Path)
+	require.Equal(t, filepath.Join(dir, "date=2026-10-16", "country=US", "part-0002.csv.gz"), list[6].Path)
+
+	opts.Dir = filepath.Join(dir, "append")
+	opts.Reopen = files.ReopenAppend
+	list = writePartitioned(t, opts)
+	require.Equal(t, 6, len(list))
+
+	var total int64
+	for _, file := range list {
+		require.Equal(t, int64(10), file.Records)
+		reader, err := files.OpenFile(file.Path)
+		require.NoError(t, err)
+		require.Equal(t, opts.Header, reader.Header())
+		cnt, err := files.CopyRecords(&recordBuffer{}, reader)
+		require.NoError(t, err)
+		require.Equal(t, int64(10), cnt)
+		reader.Close()
+		total += cnt
+	}
+	require.Equal(t, int64(60), total)
+}
+
+func TestEscapePartitionValue(t *testing.T) {
+	require.Equal(t, "US", files.EscapePartitionValue("US"))
+	require.Equal(t, "a%2Fb%3Dc", files.EscapePartitionValue("a/b=c"))
+	require.Equal(t, files.HiveDefaultPartition, files.EscapePartitionValue(""))
+}
+
+type recordBuffer struct {
+	records []files.Record
+}
+
+func (t *recordBuffer) Write(record files.Record) error {
+	t.records = append(t.records, record)
+	return nil
+}
+
+func (t *recordBuffer) Flush() error {
+	return nil
+}
+
+func (t *recordBuffer) Close() error {
+	return nil
+}
@@ -0,0 +1,446 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress of reading or writing records, CompressedBytes is the offset in the underlying files.
+type Progress struct {
+	Records         int64
+	Bytes           int64 // uncompressed bytes read or written
+	CompressedBytes int64 // bytes read from or written to the files, equal to Bytes for plain files
+	TotalBytes      int64 // size of the input files, 0 when unknown
+	Elapsed         time.Duration
+	Done            bool // the input is read to the end or the output is closed
+}
+
+// Fraction is the completed fraction of the input by the file offset, 0 when the size is unknown.
+func (t Progress) Fraction() float64 {
+	if t.TotalBytes <= 0 {
+		return 0
+	}
+	if t.CompressedBytes >= t.TotalBytes {
+		return 1
+	}
+	return float64(t.CompressedBytes) / float64(t.TotalBytes)
+}
+
+// Remaining estimates the time to completion by the current rate, 0 when it is unknown.
+func (t Progress) Remaining() time.Duration {
+	f := t.Fraction()
+	if f <= 0 || f >= 1 {
+		return 0
+	}
+	return time.Duration(float64(t.Elapsed) * (1 - f) / f)
+}
+
+// ProgressObserver receives the progress every ProgressInterval and once more when done, calls are serialized.
+type ProgressObserver interface {
+	OnProgress(p Progress)
+}
+
+type ProgressFunc func(p Progress)
+
+func (f ProgressFunc) OnProgress(p Progress) {
+	f(p)
+}
+
+// progressTracker counts the progress of a reader or writer for the optional observer and metrics.
+type progressTracker struct {
+	mu       sync.Mutex
+	observer ProgressObserver
+	metrics  *fileMetrics
+	progress Progress
+	start    time.Time
+	last     time.Time
+	done     bool
+}
+
+func newProgressTracker(observer ProgressObserver, totalBytes int64) *progressTracker {
+	now := time.Now()
+	return &progressTracker{
+		observer: observer,
+		progress: Progress{TotalBytes: totalBytes},
+		start:    now,
+		last:     now,
+	}
+}
+
+func filesSize(filePaths []string) int64 {
+	var total int64
+	for _, filePath := range filePaths {
+		if fi, err := os.Stat(filePath); err == nil {
+			total += fi.Size()
+		}
+	}
+	return total
+}
+
+// newFilesProgressTracker takes the total size of the files, nil observer returns nil tracker.
+func newFilesProgressTracker(observer ProgressObserver, filePaths []string) *progressTracker {
+	if observer == nil {
+		return nil
+	}
+	return newProgressTracker(observer, filesSize(filePaths))
+}
+
+func (t *progressTracker) add(records, bytes, compressedBytes int64) {
+	if t.metrics != nil {
+		t.metrics.add(records, bytes, compressedBytes)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Records += records
+	t.progress.Bytes += bytes
+	t.progress.CompressedBytes += compressedBytes
+	if t.observer == nil || t.done {
+		return
+	}
+	if now := time.Now(); now.Sub(t.last) >= ProgressInterval {
+		t.last = now
+		t.progress.Elapsed = now.Sub(t.start)
+		t.observer.OnProgress(t.progress)
+	}
+}
+
+func (t *progressTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done && t.observer != nil {
+		t.progress.Elapsed = time.Since(t.start)
+		t.progress.Done = true
+		t.observer.OnProgress(t.progress)
+	}
+	t.done = true
+}
+
+func (t *progressTracker) snapshot() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.progress
+}
+
+func (t *progressTracker) observeError(err error) {
+	if t.metrics != nil {
+		t.metrics.observeError(err)
+	}
+}
+
+type progressReader struct {
+	r     io.Reader
+	count func(n int64)
+}
+
+func (t *progressReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.count(int64(n))
+	return n, err
+}
+
+type progressWriter struct {
+	w     io.Writer
+	count func(n int64)
+}
+
+func (t *progressWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.count(int64(n))
+	return n, err
+}
+
+// open reads the records of the format from r, gzip is decoded here to count the bytes on both sides.
+func (t *progressTracker) open(format *Format, r io.Reader, gzipEnabled bool) (*progressRecordReader, error) {
+
+	if !gzipEnabled {
+		src := &progressReader{r, func(n int64) { t.add(0, n, n) }}
+		reader, err := format.Open(bufio.NewReaderSize(src, FileRWBlockSize), false)
+		if err != nil {
+			return nil, err
+		}
+		return &progressRecordReader{RecordReader: reader, tracker: t}, nil
+	}
+
+	src := &progressReader{r, func(n int64) { t.add(0, 0, n) }}
+	gzr, err := gzip.NewReader(bufio.NewReaderSize(src, FileRWBlockSize))
+	if err != nil {
+		return nil, errors.Errorf("gzip read error, %v", err)
+	}
+
+	reader, err := format.Open(&progressReader{gzr, func(n int64) { t.add(0, n, 0) }}, false)
+	if err != nil {
+		gzr.Close()
+		return nil, err
+	}
+	return &progressRecordReader{RecordReader: reader, tracker: t, gzr: gzr}, nil
+}
+
+type progressRecordReader struct {
+	RecordReader
+	tracker *progressTracker
+	gzr     *gzip.Reader
+	fd      *os.File
+	eof     bool // finish the progress at the end of the input
+}
+
+func (t *progressRecordReader) Read() (Record, error) {
+	record, err := t.RecordReader.Read()
+	switch {
+	case err == nil:
+		t.tracker.add(1, 0, 0)
+	case err == io.EOF:
+		if t.eof {
+			t.tracker.finish()
+		}
+	default:
+		t.tracker.observeError(err)
+	}
+	return record, err
+}
+
+func (t *progressRecordReader) Close() error {
+	start := time.Now()
+	err := t.RecordReader.Close()
+	if t.gzr != nil {
+		t.gzr.Close()
+	}
+	if t.fd != nil {
+		if closeErr := t.fd.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if m := t.tracker.metrics; m != nil {
+		m.observeLatency(m.close, start)
+		if t.gzr != nil {
+			m.observeRatio(t.tracker.snapshot())
+		}
+		m.observeError(err)
+	}
+	return err
+}
+
+func (t *progressTracker) openFile(format *Format, filePath string, gzipEnabled bool) (*progressRecordReader, error) {
+
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+
+	reader, err := t.open(format, fd, gzipEnabled)
+	if err != nil {
+		fd.Close()
+		return nil, errors.Errorf("open %s records in '%s', %v", format.Name, filePath, err)
+	}
+
+	reader.fd = fd
+	return reader, nil
+}
+
+// openProgressKindFile opens the part of a multi-file operation, nil tracker opens the file without progress.
+func openProgressKindFile(kind string, filePath string, tracker *progressTracker) (RecordReader, error) {
+	if tracker == nil {
+		return openKindFile(kind, filePath)
+	}
+	format, gzipEnabled, err := kindFileFormat(kind, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tracker.openFile(format, filePath, gzipEnabled)
+}
+
+// progressInput is the decoded content of the file for the typed stream readers, the bytes are counted on both sides of gzip.
+type progressInput struct {
+	io.Reader
+	gzr *gzip.Reader
+	fd  *os.File
+}
+
+func (t *progressTracker) openInput(filePath string) (*progressInput, error) {
+
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Errorf("file open error '%s', %v", filePath, err)
+	}
+
+	if !strings.HasSuffix(filePath, ".gz") {
+		src := &progressReader{fd, func(n int64) { t.add(0, n, n) }}
+		return &progressInput{Reader: bufio.NewReaderSize(src, FileRWBlockSize), fd: fd}, nil
+	}
+
+	src := &progressReader{fd, func(n int64) { t.add(0, 0, n) }}
+	gzr, err := gzip.NewReader(bufio.NewReaderSize(src, FileRWBlockSize))
+	if err != nil {
+		fd.Close()
+		return nil, errors.Errorf("gzip read error in '%s', %v", filePath, err)
+	}
+	return &progressInput{Reader: &progressReader{gzr, func(n int64) { t.add(0, n, 0) }}, gzr: gzr, fd: fd}, nil
+}
+
+func (t *progressInput) Close() error {
+	if t.gzr != nil {
+		t.gzr.Close()
+	}
+	return t.fd.Close()
+}
+
+// FileOptions instrument the readers and writers of files, all options are optional.
+type FileOptions struct {
+	Progress ProgressObserver
+	Metrics  Metrics
+}
+
+func (t FileOptions) instrumented() bool {
+	return t.Progress != nil || t.Metrics != nil
+}
+
+// OpenFileWith opens the file like OpenFile with the instrumentation of options.
+func OpenFileWith(filePath string, opts FileOptions) (RecordReader, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.OpenFileWith(filePath, gzipEnabled, opts)
+}
+
+func (t *Format) OpenFileWith(filePath string, gzipEnabled bool, opts FileOptions) (RecordReader, error) {
+
+	if !opts.instrumented() {
+		return t.OpenFile(filePath, gzipEnabled)
+	}
+
+	tracker := newProgressTracker(opts.Progress, filesSize([]string{filePath}))
+	tracker.metrics = newReadMetrics(opts.Metrics, formatName(t, gzipEnabled))
+
+	reader, err := tracker.openFile(t, filePath, gzipEnabled)
+	if err != nil {
+		tracker.observeError(err)
+		return nil, err
+	}
+
+	reader.eof = true
+	return reader, nil
+}
+
+// OpenFileProgress opens the file like OpenFile reporting the reading progress to the observer.
+func OpenFileProgress(filePath string, observer ProgressObserver) (RecordReader, error) {
+	return OpenFileWith(filePath, FileOptions{Progress: observer})
+}
+
+func (t *Format) OpenFileProgress(filePath string, gzipEnabled bool, observer ProgressObserver) (RecordReader, error) {
+	return t.OpenFileWith(filePath, gzipEnabled, FileOptions{Progress: observer})
+}
+
+// CreateFileWith creates the file like CreateFile with the instrumentation of options.
+func CreateFileWith(filePath string, header []string, opts FileOptions) (RecordWriter, error) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.CreateFileWith(filePath, gzipEnabled, header, opts)
+}
+
+// CreateFileProgress creates the file like CreateFile reporting the writing progress to the observer.
+func CreateFileProgress(filePath string, header []string, observer ProgressObserver) (RecordWriter, error) {
+	return CreateFileWith(filePath, header, FileOptions{Progress: observer})
+}
+
+func (t *Format) CreateFileProgress(filePath string, gzipEnabled bool, header []string, observer ProgressObserver) (RecordWriter, error) {
+	return t.CreateFileWith(filePath, gzipEnabled, header, FileOptions{Progress: observer})
+}
+
+func (t *Format) CreateFileWith(filePath string, gzipEnabled bool, header []string, opts FileOptions) (RecordWriter, error) {
+
+	if !opts.instrumented() {
+		return t.CreateFile(filePath, gzipEnabled, header)
+	}
+
+	tracker := newProgressTracker(opts.Progress, 0)
+	tracker.metrics = newWriteMetrics(opts.Metrics, formatName(t, gzipEnabled))
+
+	fd, err := createFile(filePath)
+	if err != nil {
+		tracker.observeError(err)
+		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
+	}
+
+	w := &progressRecordWriter{
+		tracker: tracker,
+		fw:      bufio.NewWriterSize(fd, FileRWBlockSize),
+		fd:      fd,
+	}
+
+	var out io.Writer = &progressWriter{w.fw, func(n int64) { tracker.add(0, n, n) }}
+	if gzipEnabled {
+		w.gzw = gzip.NewWriter(&progressWriter{w.fw, func(n int64) { tracker.add(0, 0, n) }})
+		out = &progressWriter{w.gzw, func(n int64) { tracker.add(0, n, 0) }}
+	}
+
+	w.RecordWriter, err = t.Create(out, false, header)
+	if err != nil {
+		tracker.observeError(err)
+		fd.Close()
+		os.Remove(filePath)
+		return nil, errors.Errorf("create %s records in '%s', %v", t.Name, filePath, err)
+	}
+
+	return w, nil
+}
+
+type progressRecordWriter struct {
+	RecordWriter
+	tracker *progressTracker
+	gzw     *gzip.Writer
+	fw      *bufio.Writer
+	fd      *os.File
+}
+
+func (t *progressRecordWriter) Write(record Record) error {
+	if err := t.RecordWriter.Write(record); err != nil {
+		t.tracker.observeError(err)
+		return err
+	}
+	t.tracker.add(1, 0, 0)
+	return nil
+}
+
+func (t *progressRecordWriter) Flush() error {
+	start := time.Now()
+	err := t.flush()
+	if m := t.tracker.metrics; m != nil {
+		m.observeLatency(m.flush, start)
+		m.observeError(err)
+	}
+	return err
+}
+
+func (t *progressRecordWriter) flush() error {
+	return flushLayers(t.RecordWriter.Flush, gzipFlush(t.gzw), t.fw.Flush)
+}
+
+func (t *progressRecordWriter) Close() error {
+	start := time.Now()
+	err := t.close()
+	if m := t.tracker.metrics; m != nil {
+		m.observeLatency(m.close, start)
+		if t.gzw != nil {
+			m.observeRatio(t.tracker.snapshot())
+		}
+		m.observeError(err)
+	}
+	t.tracker.finish()
+	return err
+}
+
+func (t *progressRecordWriter) close() error {
+	return closeLayers(t.RecordWriter.Close, gzipClose(t.gzw), t.fw.Flush, syncOnClose(t.fd), t.fd.Close)
+}
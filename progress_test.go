@@ -0,0 +1,204 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type progressLog struct {
+	list []files.Progress
+}
+
+func (t *progressLog) OnProgress(p files.Progress) {
+	t.list = append(t.list, p)
+}
+
+func (t *progressLog) last() files.Progress {
+	return t.list[len(t.list)-1]
+}
+
+func TestFileProgress(t *testing.T) {
+
+	interval := files.ProgressInterval
+	files.ProgressInterval = 0
+	defer func() { files.ProgressInterval = interval }()
+
+	filePath := tempFilePath(t, "progress-test") + ".csv.gz"
+	defer os.Remove(filePath)
+
+	written := new(progressLog)
+	writer, err := files.CreateFileProgress(filePath, []string{"name", "count"}, written)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+
+	p := written.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(1000), p.Records)
+	require.Equal(t, fi.Size(), p.CompressedBytes)
+	require.True(t, p.Bytes > p.CompressedBytes)
+
+	read := new(progressLog)
+	reader, err := files.OpenFileProgress(filePath, read)
+	require.NoError(t, err)
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	require.NoError(t, reader.Close())
+
+	p = read.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(1000), p.Records)
+	require.Equal(t, fi.Size(), p.TotalBytes)
+	require.Equal(t, fi.Size(), p.CompressedBytes)
+	require.Equal(t, written.last().Bytes, p.Bytes)
+	require.Equal(t, 1.0, p.Fraction())
+
+	for _, p := range read.list[:len(read.list)-1] {
+		require.False(t, p.Done)
+	}
+}
+
+func TestSplitAndJoinProgress(t *testing.T) {
+
+	interval := files.ProgressInterval
+	files.ProgressInterval = 0
+	defer func() { files.ProgressInterval = interval }()
+
+	filePath := tempFilePath(t, "progress-test")
+	csvFilePath := filePath + ".csv"
+	defer os.Remove(csvFilePath)
+
+	writer, err := files.CreateFile(csvFilePath, []string{"name"})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	fi, err := os.Stat(csvFilePath)
+	require.NoError(t, err)
+
+	split := new(progressLog)
+	parts, err := files.SplitFileWith(csvFilePath, files.SplitOptions{Limit: 30, Progress: split}, func(i int) string {
+		return fmt.Sprintf("%s_part%d.csv.gz", filePath, i)
+	})
+	require.NoError(t, err)
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	p := split.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(100), p.Records)
+	require.Equal(t, fi.Size(), p.Bytes)
+	require.Equal(t, fi.Size(), p.TotalBytes)
+
+	var total int64
+	for _, part := range parts {
+		fi, err := os.Stat(part)
+		require.NoError(t, err)
+		total += fi.Size()
+	}
+
+	outPath := filePath + "_joined.csv"
+	defer os.Remove(outPath)
+
+	join := new(progressLog)
+	_, err = files.JoinFilesWith(outPath, parts, files.JoinOptions{Progress: join})
+	require.NoError(t, err)
+
+	p = join.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(100), p.Records)
+	require.Equal(t, total, p.TotalBytes)
+	require.Equal(t, total, p.CompressedBytes)
+	require.Equal(t, fi.Size()+int64(len("name\n"))*int64(len(parts)-1), p.Bytes) // each part has the header
+}
+
+func TestProgressRemaining(t *testing.T) {
+
+	p := files.Progress{CompressedBytes: 25, TotalBytes: 100, Elapsed: time.Second}
+	require.Equal(t, 0.25, p.Fraction())
+	require.Equal(t, 3*time.Second, p.Remaining())
+
+	require.Equal(t, time.Duration(0), files.Progress{Elapsed: time.Second}.Remaining())
+}
+
+func TestConvertProgress(t *testing.T) {
+
+	interval := files.ProgressInterval
+	files.ProgressInterval = 0
+	defer func() { files.ProgressInterval = interval }()
+
+	filePath := tempFilePath(t, "progress-test")
+	csvFilePath := filePath + ".csv.gz"
+	jsonFilePath := filePath + ".jsonl"
+	resultFilePath := filePath + "_result.csv"
+	defer os.Remove(csvFilePath)
+	defer os.Remove(jsonFilePath)
+	defer os.Remove(resultFilePath)
+
+	writer, err := files.CreateFile(csvFilePath, []string{"name", "count"})
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	fi, err := os.Stat(csvFilePath)
+	require.NoError(t, err)
+
+	read := new(progressLog)
+	report, err := files.ConvertFile(csvFilePath, jsonFilePath, files.ConvertOptions{Progress: read})
+	require.NoError(t, err)
+	require.Equal(t, 1000, report.Records)
+
+	p := read.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(1000), p.Records)
+	require.Equal(t, fi.Size(), p.TotalBytes)
+	require.Equal(t, fi.Size(), p.CompressedBytes)
+	require.True(t, p.Bytes > p.CompressedBytes)
+	require.True(t, len(read.list) > 1)
+
+	fi, err = os.Stat(jsonFilePath)
+	require.NoError(t, err)
+
+	// the columns are discovered by an extra pass over the input
+	read = new(progressLog)
+	report, err = files.ConvertFile(jsonFilePath, resultFilePath, files.ConvertOptions{
+		Flatten:  files.FlattenOptions{SampleSize: -1},
+		Progress: read,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1000, report.Records)
+
+	p = read.last()
+	require.True(t, p.Done)
+	require.Equal(t, int64(2000), p.Records)
+	require.Equal(t, 2*fi.Size(), p.TotalBytes)
+	require.Equal(t, 2*fi.Size(), p.Bytes)
+	require.Equal(t, 1.0, p.Fraction())
+}
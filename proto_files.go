@@ -55,27 +55,37 @@ func (t *protoStreamReader) Close() error {
 }
 
 func (t *protoStreamReader) ReadTo(message proto.Message) error {
+	block, err := ProtobufReadRaw(t.r, t.lenBuf[:])
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(block, message)
+}
+
+func (t *protoStreamReader) ReadRaw() ([]byte, error) {
+	return ProtobufReadRaw(t.r, t.lenBuf[:])
+}
 
-	lenBuf := t.lenBuf[:]
+func ProtobufReadRaw(r io.Reader, lenBuf []byte) ([]byte, error) {
 
-	n, err := io.ReadFull(t.r, lenBuf)
+	n, err := io.ReadFull(r, lenBuf)
 	if err != nil {
-		return err
+		return nil, err
 	} else if n != len(lenBuf) {
-		return errors.Errorf("wrong number read %d, expected %d", n, len(lenBuf))
+		return nil, errors.Errorf("wrong number read %d, expected %d", n, len(lenBuf))
 	}
 
 	blockLen := int(binary.BigEndian.Uint32(lenBuf))
 
 	block := make([]byte, blockLen)
-	n, err = io.ReadFull(t.r, block)
+	n, err = io.ReadFull(r, block)
 	if err != nil {
-		return err
+		return nil, err
 	} else if n != len(block) {
-		return errors.Errorf("wrong read bytes %d expected %d", n, len(block))
+		return nil, errors.Errorf("wrong read bytes %d expected %d", n, len(block))
 	}
 
-	return proto.Unmarshal(block, message)
+	return block, nil
 }
 
 type protoFileReader struct {
@@ -127,29 +137,17 @@ func (t *protoFileReader) Close() error {
 }
 
 func (t *protoFileReader) ReadTo(message proto.Message) error {
-
-	lenBuf := t.lenBuf[:]
-
-	n, err := io.ReadFull(t.r, lenBuf)
+	block, err := ProtobufReadRaw(t.r, t.lenBuf[:])
 	if err != nil {
 		return err
-	} else if n != len(lenBuf) {
-		return errors.Errorf("wrong number read %d, expected %d", n, len(lenBuf))
 	}
-
-	blockLen := int(binary.BigEndian.Uint32(lenBuf))
-
-	block := make([]byte, blockLen)
-	n, err = io.ReadFull(t.r, block)
-	if err != nil {
-		return err
-	} else if n != len(block) {
-		return errors.Errorf("wrong read bytes %d expected %d", n, len(block))
-	}
-
 	return proto.Unmarshal(block, message)
 }
 
+func (t *protoFileReader) ReadRaw() ([]byte, error) {
+	return ProtobufReadRaw(t.r, t.lenBuf[:])
+}
+
 type protoStreamWriter struct {
 	fd   io.Writer
 	fw   *bufio.Writer
@@ -177,47 +175,56 @@ func NewProtoStream(fd io.Writer, gzipEnabled bool) ProtoWriter {
 	return t
 }
 
-func (t *protoStreamWriter) Close() (err error) {
-	if t.bw != nil {
-		t.bw.Flush()
-	}
-	if t.gzw != nil {
-		t.gzw.Flush()
-		err = t.gzw.Close()
-	}
-	t.fw.Flush()
-	return err
+func (t *protoStreamWriter) Flush() error {
+	return flushLayers(bufferFlush(t.bw), gzipFlush(t.gzw), t.fw.Flush)
+}
+
+func (t *protoStreamWriter) Close() error {
+	return closeLayers(bufferFlush(t.bw), gzipClose(t.gzw), t.fw.Flush)
+}
+
+func (t *protoStreamWriter) Sync() error {
+	return flushLayers(t.Flush, writerSync(t.fd))
 }
 
 func (t *protoStreamWriter) Write(message proto.Message) ([]byte, error) {
 	return ProtobufWrite(t.w, message)
 }
 
-func ProtobufWrite(w io.Writer, message proto.Message) ([]byte, error) {
+func (t *protoStreamWriter) WriteRaw(blob []byte) error {
+	return ProtobufWriteRaw(t.w, blob)
+}
 
-	var lenBufArr  [4]byte
-	lenBuf := lenBufArr[:]
+func ProtobufWrite(w io.Writer, message proto.Message) ([]byte, error) {
 
 	blob, err := proto.Marshal(message)
 	if err != nil {
 		return nil, errors.Errorf("proto marshal error, %v", err)
 	}
 
+	return blob, ProtobufWriteRaw(w, blob)
+}
+
+func ProtobufWriteRaw(w io.Writer, blob []byte) error {
+
+	var lenBufArr  [4]byte
+	lenBuf := lenBufArr[:]
+
 	binary.BigEndian.PutUint32(lenBuf, uint32(len(blob)))
 
 	if n, err := w.Write(lenBuf); err != nil {
-		return blob, err
+		return err
 	} else if n != len(lenBuf) {
-		return blob, errors.Errorf("wrong number written %d, expected %d", n, len(lenBuf))
+		return errors.Errorf("wrong number written %d, expected %d", n, len(lenBuf))
 	}
 
 	if n, err := w.Write(blob); err != nil {
-		return blob, err
+		return err
 	} else if n != len(blob) {
-		return blob, errors.Errorf("wrong number written %d, expected %d", n, len(blob))
+		return errors.Errorf("wrong number written %d, expected %d", n, len(blob))
 	}
 
-	return blob, nil
+	return nil
 }
 
 type protoBufWriter struct {
@@ -242,15 +249,17 @@ func NewProtoBuf(gzipEnabled bool) (ProtoWriter, error) {
 	return t, nil
 }
 
+func (t *protoBufWriter) Flush() error {
+	return flushLayers(bufferFlush(t.bw), gzipFlush(t.gzw))
+}
+
+// Sync of the buffer is Flush, there is no file to sync.
+func (t *protoBufWriter) Sync() error {
+	return t.Flush()
+}
+
 func (t *protoBufWriter) Close() error {
-	if t.bw != nil {
-		t.bw.Flush()
-	}
-	if t.gzw != nil {
-		t.gzw.Flush()
-		t.gzw.Close()
-	}
-	return nil
+	return closeLayers(bufferFlush(t.bw), gzipClose(t.gzw))
 }
 
 func (t *protoBufWriter) Buffer() io.Reader {
@@ -265,6 +274,10 @@ func (t *protoBufWriter) Write(message proto.Message) ([]byte, error) {
 	return ProtobufWrite(t.w, message)
 }
 
+func (t *protoBufWriter) WriteRaw(blob []byte) error {
+	return ProtobufWriteRaw(t.w, blob)
+}
+
 type protoFileWriter struct {
 	fd   *os.File
 	fw   *bufio.Writer
@@ -275,17 +288,23 @@ type protoFileWriter struct {
 
 func NewProtoFile(filePath string) (ProtoWriter, error) {
 
-	var err error
-	t := new(protoFileWriter)
-
-	t.fd, err = os.Create(filePath)
+	fd, err := createFile(filePath)
 	if err != nil {
 		return nil, errors.Errorf("file create error '%s', %v", filePath, err)
 	}
 
+	return newProtoFileWriter(fd, strings.HasSuffix(filePath, ".gz")), nil
+}
+
+func newProtoFileWriter(fd *os.File, gzipEnabled bool) *protoFileWriter {
+
+	t := &protoFileWriter{
+		fd: fd,
+	}
+
 	t.fw = bufio.NewWriterSize(t.fd, FileRWBlockSize)
 
-	if strings.HasSuffix(filePath, ".gz") {
+	if gzipEnabled {
 		t.gzw = gzip.NewWriter(t.fw)
 		t.bw = bufio.NewWriterSize(t.gzw, FileRWBlockSize)
 		t.w = t.bw
@@ -293,122 +312,37 @@ func NewProtoFile(filePath string) (ProtoWriter, error) {
 		t.w = t.fw
 	}
 
-	return t, nil
+	return t
+}
+
+func (t *protoFileWriter) Flush() error {
+	return flushLayers(bufferFlush(t.bw), gzipFlush(t.gzw), t.fw.Flush)
 }
 
 func (t *protoFileWriter) Close() error {
-	if t.bw != nil {
-		t.bw.Flush()
-	}
-	if t.gzw != nil {
-		t.gzw.Flush()
-		t.gzw.Close()
-	}
-	t.fw.Flush()
-	return t.fd.Close()
+	return closeLayers(bufferFlush(t.bw), gzipClose(t.gzw), t.fw.Flush, syncOnClose(t.fd), t.fd.Close)
+}
+
+func (t *protoFileWriter) Sync() error {
+	return flushLayers(t.Flush, t.fd.Sync)
 }
 
 func (t *protoFileWriter) Write(message proto.Message) ([]byte, error) {
 	return ProtobufWrite(t.w, message)
 }
 
-func SplitProtoFile(inputFilePath string, holder proto.Message, limit int, partFn func (int) string) ([]string, error) {
-
-	reader, err := OpenProtoFile(inputFilePath)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-
-	var parts []string
-	var writer ProtoWriter
-
-	partNum := 1
-	for cnt := limit; err == nil; cnt++ {
-
-		err = reader.ReadTo(holder)
-		if err != nil {
-			break
-		}
-
-		if cnt == limit {
-			if writer != nil {
-				writer.Close()
-				writer = nil
-			}
-			partFilePath := partFn(partNum)
-			writer, err = NewProtoFile(partFilePath)
-			if err != nil {
-				break
-			}
-			parts = append(parts, partFilePath)
-			cnt = 0
-			partNum++
-		}
-
-		_, err = writer.Write(holder)
-	}
-
-	if err == io.EOF {
-		err = nil
-	}
-
-	if writer != nil {
-		writer.Close()
-	}
-
-	if err != nil {
-		for _, part := range parts {
-			os.Remove(part)
-		}
-		parts = nil
-	}
+func (t *protoFileWriter) WriteRaw(blob []byte) error {
+	return ProtobufWriteRaw(t.w, blob)
+}
 
-	return parts, err
+// SplitProtoFile copies raw messages to the parts, the holder is not used anymore and kept for compatibility.
+func SplitProtoFile(inputFilePath string, holder proto.Message, limit int, partFn func (int) string) ([]string, error) {
+	return SplitProtoFileWith(inputFilePath, SplitOptions{Limit: limit}, partFn)
 }
 
+// JoinProtoFiles joins length-delimited proto files, the records are copied as is so row is not used.
 func JoinProtoFiles(outputFilePath string, row proto.Message, parts []string) error {
-
-	writer, err := NewProtoFile(outputFilePath)
-	if err != nil {
-		return err
-	}
-	defer writer.Close()
-
-	for _, part := range parts {
-
-		reader, err := OpenProtoFile(part)
-		if err != nil {
-			return errors.Errorf("can not open file '%s', %v", part, err)
-		}
-
-		for {
-
-			err = reader.ReadTo(row)
-			if err != nil {
-				break
-			}
-
-			_, err = writer.Write(row)
-			if err != nil {
-				reader.Close()
-				return errors.Errorf("can not write row to file '%s', %v", outputFilePath, err)
-			}
-
-		}
-
-		if err == io.EOF {
-			err = nil
-		}
-
-		reader.Close()
-
-		if err != nil {
-			return errors.Errorf("join read file '%s', %v", part, err)
-		}
-
-	}
-
-	return nil
+	_, err := JoinProtoFilesWith(outputFilePath, parts, JoinOptions{})
+	return err
 }
 
@@ -149,6 +149,12 @@ func TestProtoSplit(t *testing.T) {
 
 	require.Equal(t, all, joined)
 
+	// a failed join removes the partial output
+	err = files.JoinProtoFiles(protoFilePath, obj1, append(parts, filePath+"_missing.pb"))
+	require.Error(t, err)
+	_, err = os.Stat(protoFilePath)
+	require.True(t, os.IsNotExist(err))
+
 	os.Remove(protoFilePath)
 	for _, part := range parts {
 		println("RemoveFile: ", part)
@@ -0,0 +1,92 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+)
+
+// protoJsonWriter writes every message as a single line of protojson, so the same message type
+// can be stored in binary and JSON files interchangeably.
+type protoJsonWriter struct {
+	w    JsonWriter
+	opts protojson.MarshalOptions
+}
+
+func NewProtoJsonStream(fd io.Writer, gzipEnabled bool, opts protojson.MarshalOptions) ProtoJsonWriter {
+	return newProtoJsonWriter(NewJsonStream(fd, gzipEnabled), opts)
+}
+
+func NewProtoJsonFile(filePath string, opts protojson.MarshalOptions) (ProtoJsonWriter, error) {
+	w, err := NewJsonFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return newProtoJsonWriter(w, opts), nil
+}
+
+func newProtoJsonWriter(w JsonWriter, opts protojson.MarshalOptions) *protoJsonWriter {
+	opts.Multiline = false
+	opts.Indent = ""
+	return &protoJsonWriter{
+		w:    w,
+		opts: opts,
+	}
+}
+
+func (t *protoJsonWriter) Flush() error {
+	return t.w.Flush()
+}
+
+func (t *protoJsonWriter) Sync() error {
+	return t.w.Sync()
+}
+
+func (t *protoJsonWriter) Close() error {
+	return t.w.Close()
+}
+
+func (t *protoJsonWriter) Write(message proto.Message) error {
+	jsonBin, err := t.opts.Marshal(proto.MessageV2(message))
+	if err != nil {
+		return err
+	}
+	return t.w.WriteRaw(jsonBin)
+}
+
+type protoJsonReader struct {
+	r    JsonReader
+	opts protojson.UnmarshalOptions
+}
+
+func ProtoJsonStream(fr io.Reader, gzipEnabled bool, opts protojson.UnmarshalOptions) (ProtoJsonReader, error) {
+	r, err := JsonStream(fr, gzipEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return &protoJsonReader{r: r, opts: opts}, nil
+}
+
+func OpenProtoJsonFile(filePath string, opts protojson.UnmarshalOptions) (ProtoJsonReader, error) {
+	r, err := OpenJsonFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &protoJsonReader{r: r, opts: opts}, nil
+}
+
+func (t *protoJsonReader) Close() error {
+	return t.r.Close()
+}
+
+func (t *protoJsonReader) ReadTo(message proto.Message) error {
+	jsonBin, err := t.r.ReadRaw()
+	if err != nil {
+		return err
+	}
+	return t.opts.Unmarshal(jsonBin, proto.MessageV2(message))
+}
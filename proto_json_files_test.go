@@ -0,0 +1,111 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProtoJsonInterchange(t *testing.T) {
+
+	fd, err := ioutil.TempFile(os.TempDir(), "proto-json-test")
+	require.NoError(t, err)
+	filePath := fd.Name()
+	fd.Close()
+	os.Remove(filePath)
+
+	protoFilePath := filePath + ".pb.gz"
+	jsonFilePath := filePath + ".json.gz"
+	defer os.Remove(protoFilePath)
+	defer os.Remove(jsonFilePath)
+
+	writeProto(t, protoFilePath)
+
+	reader, err := files.OpenProtoFile(protoFilePath)
+	require.NoError(t, err)
+
+	writer, err := files.NewProtoJsonFile(jsonFilePath, files.Marshaler.MarshalOptions)
+	require.NoError(t, err)
+
+	var expected []*Domain
+	for {
+		var obj Domain
+		err = reader.ReadTo(&obj)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(&obj))
+		expected = append(expected, &obj)
+	}
+	require.NoError(t, reader.Close())
+	require.NoError(t, writer.Close())
+	require.Equal(t, 2, len(expected))
+
+	jsonReader, err := files.OpenProtoJsonFile(jsonFilePath, protojson.UnmarshalOptions{})
+	require.NoError(t, err)
+
+	for _, exp := range expected {
+		obj := &Domain{Zone: "reset"}
+		err = jsonReader.ReadTo(obj)
+		require.NoError(t, err)
+		require.True(t, proto.Equal(exp, obj))
+	}
+
+	err = jsonReader.ReadTo(&Domain{})
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, jsonReader.Close())
+
+	// the json file is readable by the plain json reader
+	plain, err := files.OpenJsonFile(jsonFilePath)
+	require.NoError(t, err)
+	m := make(map[string]interface{})
+	require.NoError(t, plain.Read(&m))
+	require.Equal(t, "obj1", m["domain"])
+	require.NoError(t, plain.Close())
+}
+
+func TestProtoJsonResolver(t *testing.T) {
+
+	resolver := new(protoregistry.Types)
+	err := resolver.RegisterMessage(proto.MessageV2(&Domain{}).ProtoReflect().Type())
+	require.NoError(t, err)
+
+	any, err := anypb.New(proto.MessageV2(&Domain{Domain: "obj1", Zone: "example.com"}))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writer := files.NewProtoJsonStream(&buf, false, protojson.MarshalOptions{Resolver: resolver, Multiline: true})
+	require.NoError(t, writer.Write(any))
+	require.NoError(t, writer.Close())
+
+	require.Equal(t, 1, bytes.Count(buf.Bytes(), []byte{'\n'}))
+	require.Contains(t, buf.String(), "\"@type\":")
+
+	reader, err := files.ProtoJsonStream(bytes.NewReader(buf.Bytes()), false, protojson.UnmarshalOptions{Resolver: resolver})
+	require.NoError(t, err)
+
+	var actual anypb.Any
+	require.NoError(t, reader.ReadTo(&actual))
+
+	var obj Domain
+	require.NoError(t, actual.UnmarshalTo(proto.MessageV2(&obj)))
+	require.Equal(t, "example.com", obj.Zone)
+
+	// unknown types fail with an empty resolver
+	reader, err = files.ProtoJsonStream(bytes.NewReader(buf.Bytes()), false, protojson.UnmarshalOptions{Resolver: new(protoregistry.Types)})
+	require.NoError(t, err)
+	require.Error(t, reader.ReadTo(&actual))
+}
@@ -0,0 +1,106 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"strings"
+)
+
+// KeyExtractor returns the key of the record, missing values give the empty key.
+type KeyExtractor func(record Record) (string, error)
+
+// RecordKey creates the key extractor for the input, header is nil for json and proto records.
+type RecordKey func(header []string) (KeyExtractor, error)
+
+// CsvColumnKey takes the key from the csv column with the given name.
+func CsvColumnKey(column string) RecordKey {
+	return func(header []string) (KeyExtractor, error) {
+		for i, name := range header {
+			if name == column {
+				return func(record Record) (string, error) {
+					if i < len(record.Values) {
+						return record.Values[i], nil
+					}
+					return "", nil
+				}, nil
+			}
+		}
+		return nil, errors.Errorf("column '%s' not found in header %v", column, header)
+	}
+}
+
+// JsonPointerKey takes the key from the RFC 6901 pointer of json records, strings are unquoted.
+func JsonPointerKey(pointer string) RecordKey {
+	return func(header []string) (KeyExtractor, error) {
+		p, err := newJsonProjector([]string{pointer})
+		if err != nil {
+			return nil, err
+		}
+		return func(record Record) (string, error) {
+			m, err := p.extract(record.Raw)
+			if err != nil {
+				return "", err
+			}
+			return JsonValueString(m[pointer]), nil
+		}, nil
+	}
+}
+
+// ProtoFieldKey takes the key from the dot separated field path of proto records, like "owner.id".
+func ProtoFieldKey(md protoreflect.MessageDescriptor, fieldPath string) RecordKey {
+	return func(header []string) (KeyExtractor, error) {
+
+		var fields []protoreflect.FieldDescriptor
+		msgDesc := md
+		for _, name := range strings.Split(fieldPath, ".") {
+			if msgDesc == nil {
+				return nil, errors.Errorf("field '%s' of '%s' is not a message", name, fieldPath)
+			}
+			fd := msgDesc.Fields().ByName(protoreflect.Name(name))
+			if fd == nil || fd.IsList() || fd.IsMap() {
+				return nil, errors.Errorf("singular field '%s' not found in '%s'", name, msgDesc.FullName())
+			}
+			fields = append(fields, fd)
+			msgDesc = fd.Message()
+		}
+
+		if msgDesc != nil {
+			return nil, errors.Errorf("field path '%s' ends with a message", fieldPath)
+		}
+
+		return func(record Record) (string, error) {
+			msg := dynamicpb.NewMessage(md).ProtoReflect()
+			if err := proto.Unmarshal(record.Raw, msg.Interface()); err != nil {
+				return "", errors.Errorf("proto unmarshal error, %v", err)
+			}
+			for _, fd := range fields[:len(fields)-1] {
+				if !msg.Has(fd) {
+					return "", nil
+				}
+				msg = msg.Get(fd).Message()
+			}
+			return protoValueString(fields[len(fields)-1], msg.Get(fields[len(fields)-1])), nil
+		}, nil
+	}
+}
+
+func protoValueString(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprint(int32(v.Enum()))
+	default:
+		return v.String()
+	}
+}
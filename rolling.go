@@ -0,0 +1,243 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RollingOptions struct {
+	Pattern    string        // segment path, "{seq}" is required and replaced by the sequence number, "{time}" by the start time
+	TimeLayout string        // layout of "{time}", empty for "20060102T150405"
+	Header     []string      // csv header written to each segment
+	MaxBytes   int64         // max bytes per segment as stored, 0 for no limit
+	MaxRecords int64         // max records per segment, 0 for no limit
+	Interval   time.Duration // max age of a segment, idle segments are closed by a timer, 0 for no limit
+	Compress   bool          // appends ".gz" to the segment paths
+	Atomic     bool          // writes segments to temp files renamed into place when closed
+
+	// OnSegment is called with each finished segment for shipping, it must not call the writer
+	OnSegment func(segment RollingSegment)
+}
+
+// RollingSegment is a finished segment file.
+type RollingSegment struct {
+	Seq     int
+	Path    string
+	Records int64
+	Bytes   int64
+	Start   time.Time
+	End     time.Time
+}
+
+// RollingWriter writes records to a sequence of segment files rolled by size, record count or age.
+// Segments are opened by the first record, so there are no empty segments.
+// The sequence skips the paths of existing files, so a restarted writer does not overwrite earlier segments.
+type RollingWriter struct {
+	opts   RollingOptions
+	target partTarget
+	mu     sync.Mutex
+	seq    int
+	part   *partWriter
+	start  time.Time
+	timer  *time.Timer
+	err    error // first error, returned by all following calls
+	closed bool
+}
+
+func NewRollingWriter(opts RollingOptions) (*RollingWriter, error) {
+
+	if !strings.Contains(opts.Pattern, "{seq}") {
+		return nil, errors.Errorf("rolling pattern '%s' has no {seq}, segments started in the same {time} would collide", opts.Pattern)
+	}
+	if opts.TimeLayout == "" {
+		opts.TimeLayout = "20060102T150405"
+	}
+	if opts.Compress && !strings.HasSuffix(opts.Pattern, ".gz") {
+		opts.Pattern += ".gz"
+	}
+	if _, _, err := FormatOf(opts.Pattern); err != nil {
+		return nil, err
+	}
+
+	t := &RollingWriter{opts: opts}
+	if opts.Atomic {
+		t.target = atomicPartTarget("", t.segmentPath)
+	} else {
+		t.target = filePartTarget("", t.segmentPath)
+	}
+	return t, nil
+}
+
+func (t *RollingWriter) segmentPath(seq int) string {
+	path := strings.Replace(t.opts.Pattern, "{seq}", fmt.Sprintf("%04d", seq), -1)
+	return strings.Replace(path, "{time}", t.start.Format(t.opts.TimeLayout), -1)
+}
+
+func (t *RollingWriter) fail(err error) error {
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+	return err
+}
+
+func (t *RollingWriter) Write(record Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return errors.New("rolling writer is closed")
+	}
+	if t.err != nil {
+		return t.err
+	}
+
+	if t.part != nil {
+		roll, err := t.full(record)
+		if err == nil && roll {
+			err = t.roll()
+		}
+		if err != nil {
+			return t.fail(err)
+		}
+	}
+
+	if t.part == nil {
+		if err := t.open(); err != nil {
+			return t.fail(err)
+		}
+	}
+
+	return t.fail(t.part.Write(record))
+}
+
+func (t *RollingWriter) full(record Record) (bool, error) {
+	if t.opts.MaxRecords > 0 && t.part.Records >= t.opts.MaxRecords {
+		return true, nil
+	}
+	if t.opts.Interval > 0 && time.Since(t.start) >= t.opts.Interval {
+		return true, nil
+	}
+	if t.opts.MaxBytes > 0 {
+		fits, err := t.part.fits(record, t.opts.MaxBytes)
+		return !fits, err
+	}
+	return false, nil
+}
+
+func (t *RollingWriter) open() error {
+	t.start = time.Now()
+	for {
+		t.seq++
+		_, err := os.Stat(t.segmentPath(t.seq))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return errors.Errorf("segment stat error '%s', %v", t.segmentPath(t.seq), err)
+		}
+	}
+	part, err := newPartWriter(t.target, t.seq, t.opts.Header)
+	if err != nil {
+		return err
+	}
+	t.part = part
+	if t.opts.Interval > 0 {
+		t.timer = time.AfterFunc(t.opts.Interval, func() { t.expire(part) })
+	}
+	return nil
+}
+
+// expire rolls the segment that is still open after the interval.
+func (t *RollingWriter) expire(part *partWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.part == part && t.err == nil {
+		t.fail(t.roll())
+	}
+}
+
+// roll closes the current segment and reports it, the next record opens a new one.
+func (t *RollingWriter) roll() error {
+
+	part := t.part
+	t.part = nil
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+
+	if err := part.Close(); err != nil {
+		return err
+	}
+
+	if t.opts.OnSegment != nil {
+		t.opts.OnSegment(RollingSegment{
+			Seq:     part.Num,
+			Path:    part.Path,
+			Records: part.Records,
+			Bytes:   part.Bytes,
+			Start:   t.start,
+			End:     time.Now(),
+		})
+	}
+	return nil
+}
+
+// Roll closes the current segment, if any, before the limits are reached.
+func (t *RollingWriter) Roll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if t.part == nil {
+		return nil
+	}
+	return t.fail(t.roll())
+}
+
+// Flush writes the buffered records of the current segment to the file.
+func (t *RollingWriter) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	if t.part == nil {
+		return nil
+	}
+	if err := t.part.w.Flush(); err != nil {
+		return t.fail(err)
+	}
+	return t.fail(flushWriter(t.part.wc))
+}
+
+// Close finishes the current segment, segments of atomic writers that failed are removed.
+func (t *RollingWriter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return t.err
+	}
+	t.closed = true
+	if t.part != nil {
+		if t.err != nil {
+			if t.timer != nil {
+				t.timer.Stop()
+			}
+			abortPart(t.part)
+			t.part = nil
+			return t.err
+		}
+		return t.fail(t.roll())
+	}
+	return t.err
+}
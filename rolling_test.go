@@ -0,0 +1,180 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type segmentLog struct {
+	sync.Mutex
+	list []files.RollingSegment
+}
+
+func (t *segmentLog) add(segment files.RollingSegment) {
+	t.Lock()
+	t.list = append(t.list, segment)
+	t.Unlock()
+}
+
+func (t *segmentLog) segments() []files.RollingSegment {
+	t.Lock()
+	defer t.Unlock()
+	return append([]files.RollingSegment(nil), t.list...)
+}
+
+func TestRollingWriterByRecords(t *testing.T) {
+
+	filePath := tempFilePath(t, "rolling-test")
+	log := new(segmentLog)
+
+	writer, err := files.NewRollingWriter(files.RollingOptions{
+		Pattern:    filePath + "-{seq}.csv",
+		Header:     []string{"name"},
+		MaxRecords: 10,
+		Compress:   true,
+		Atomic:     true,
+		OnSegment:  log.add,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i)}}))
+	}
+	require.Equal(t, 2, len(log.segments()))
+	require.NoError(t, writer.Close())
+
+	segments := log.segments()
+	require.Equal(t, 3, len(segments))
+	for i, segment := range segments {
+		defer os.Remove(segment.Path)
+		require.Equal(t, i+1, segment.Seq)
+		require.Equal(t, fmt.Sprintf("%s-%04d.csv.gz", filePath, i+1), segment.Path)
+		require.Equal(t, 0, len(tempFilesOf(t, segment.Path)))
+
+		fi, err := os.Stat(segment.Path)
+		require.NoError(t, err)
+		require.Equal(t, fi.Size(), segment.Bytes)
+
+		cnt, err := files.CountFile(segment.Path)
+		require.NoError(t, err)
+		require.Equal(t, segment.Records, cnt)
+	}
+	require.Equal(t, int64(5), segments[2].Records)
+	require.Equal(t, []string{"name"}, readCsvRows(t, segments[2].Path)[0])
+}
+
+func TestRollingWriterByBytes(t *testing.T) {
+
+	filePath := tempFilePath(t, "rolling-test")
+	log := new(segmentLog)
+
+	writer, err := files.NewRollingWriter(files.RollingOptions{
+		Pattern:   filePath + "-{seq}.jsonl",
+		MaxBytes:  1000,
+		OnSegment: log.add,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 300; i++ {
+		require.NoError(t, writer.Write(files.Record{Raw: []byte(fmt.Sprintf(`{"n":%d}`, i))}))
+	}
+	require.NoError(t, writer.Close())
+
+	var records int64
+	for _, segment := range log.segments() {
+		defer os.Remove(segment.Path)
+		require.True(t, segment.Bytes <= 1000)
+		records += segment.Records
+	}
+	require.Equal(t, int64(300), records)
+	require.True(t, len(log.segments()) > 1)
+}
+
+func TestRollingWriterByInterval(t *testing.T) {
+
+	filePath := tempFilePath(t, "rolling-test")
+	log := new(segmentLog)
+
+	writer, err := files.NewRollingWriter(files.RollingOptions{
+		Pattern:    filePath + "-{time}-{seq}.pb",
+		TimeLayout: "150405",
+		Interval:   50 * time.Millisecond,
+		OnSegment:  log.add,
+	})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(files.Record{Raw: []byte{1}}))
+
+	// the idle segment is closed by the timer
+	require.Eventually(t, func() bool { return len(log.segments()) == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, writer.Write(files.Record{Raw: []byte{2}}))
+	require.NoError(t, writer.Close())
+
+	segments := log.segments()
+	require.Equal(t, 2, len(segments))
+	for _, segment := range segments {
+		defer os.Remove(segment.Path)
+		require.Equal(t, int64(1), segment.Records)
+		require.Equal(t, fmt.Sprintf("%s-%s-%04d.pb", filePath, segment.Start.Format("150405"), segment.Seq), segment.Path)
+	}
+}
+
+func TestRollingWriterPattern(t *testing.T) {
+	_, err := files.NewRollingWriter(files.RollingOptions{Pattern: "segment.csv"})
+	require.Error(t, err)
+	_, err = files.NewRollingWriter(files.RollingOptions{Pattern: "segment-{seq}.unknown"})
+	require.Error(t, err)
+}
+
+func TestRollingWriterSegmentsDoNotCollide(t *testing.T) {
+
+	filePath := tempFilePath(t, "rolling-test")
+
+	_, err := files.NewRollingWriter(files.RollingOptions{Pattern: filePath + "-{time}.csv"})
+	require.Error(t, err)
+
+	paths := make(map[string]bool)
+	for run := 0; run < 2; run++ {
+
+		// a restarted writer continues after the existing segments
+		log := new(segmentLog)
+		writer, err := files.NewRollingWriter(files.RollingOptions{
+			Pattern:    filePath + "-{time}-{seq}.csv",
+			TimeLayout: "20060102",
+			Header:     []string{"name"},
+			MaxRecords: 1,
+			OnSegment:  log.add,
+		})
+		require.NoError(t, err)
+
+		// all segments roll within one second
+		for i := 0; i < 5; i++ {
+			require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", run*5+i)}}))
+		}
+		require.NoError(t, writer.Close())
+
+		segments := log.segments()
+		require.Equal(t, 5, len(segments))
+		for i, segment := range segments {
+			defer os.Remove(segment.Path)
+			require.Equal(t, run*5+i+1, segment.Seq)
+			require.False(t, paths[segment.Path], segment.Path)
+			paths[segment.Path] = true
+		}
+	}
+
+	for path := range paths {
+		cnt, err := files.CountFile(path)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), cnt)
+	}
+}
@@ -0,0 +1,457 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultSortMemoryBytes = 64 << 20
+	sortRecordOverhead     = 64 // memory of the record and slice headers
+	sortMaxFanIn           = 128
+)
+
+// SortKey is a key of the sort order, values of numeric keys that are not numbers go after numbers in ascending order.
+type SortKey struct {
+	Key        RecordKey
+	Descending bool
+	Numeric    bool
+}
+
+type SortOptions struct {
+	Keys        []SortKey   // sort keys in priority order
+	Order       RecordOrder // custom order when Keys are not set
+	MemoryBytes int64       // memory for records of all sorted runs, 0 for 64MB
+	TempDir     string      // directory of the runs, empty for the system temp directory
+	Parallel    int         // number of runs sorted and written concurrently, 0 for 1
+}
+
+type SortReport struct {
+	Records int64
+	Runs    int // sorted runs spilled to temp files, 0 when the input fits in memory
+}
+
+type sortValue struct {
+	s       string
+	n       float64
+	numeric bool
+}
+
+func compareSortValue(numeric bool, a, b sortValue) int {
+	if numeric {
+		switch {
+		case a.numeric && b.numeric:
+			if a.n < b.n {
+				return -1
+			} else if a.n > b.n {
+				return 1
+			}
+			return 0
+		case a.numeric:
+			return -1
+		case b.numeric:
+			return 1
+		}
+	}
+	return strings.Compare(a.s, b.s)
+}
+
+type sortKeys struct {
+	keys       []SortKey
+	extractors []KeyExtractor
+}
+
+func newSortKeys(keys []SortKey, header []string) (*sortKeys, error) {
+	t := &sortKeys{keys: keys}
+	for _, key := range keys {
+		extractor, err := key.Key(header)
+		if err != nil {
+			return nil, err
+		}
+		t.extractors = append(t.extractors, extractor)
+	}
+	return t, nil
+}
+
+func (t *sortKeys) values(record Record) ([]sortValue, error) {
+	values := make([]sortValue, len(t.extractors))
+	for i, extractor := range t.extractors {
+		s, err := extractor(record)
+		if err != nil {
+			return nil, err
+		}
+		values[i].s = s
+		if t.keys[i].Numeric {
+			if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && n == n {
+				values[i].n, values[i].numeric = n, true
+			}
+		}
+	}
+	return values, nil
+}
+
+func (t *sortKeys) compare(a, b []sortValue) int {
+	for i, key := range t.keys {
+		if c := compareSortValue(key.Numeric, a[i], b[i]); c != 0 {
+			if key.Descending {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// OrderBy orders records by the keys in priority order, a record without one of the keys fails the sort or merge.
+func OrderBy(keys ...SortKey) RecordOrder {
+	return func(header []string) (*RecordComparator, error) {
+		sk, err := newSortKeys(keys, header)
+		if err != nil {
+			return nil, err
+		}
+		return &RecordComparator{
+			Key: func(record Record) (interface{}, error) {
+				values, err := sk.values(record)
+				if err != nil {
+					return nil, err
+				}
+				return values, nil
+			},
+			Compare: func(a, b interface{}) int {
+				return sk.compare(a.([]sortValue), b.([]sortValue))
+			},
+		}, nil
+	}
+}
+
+type externalSorter struct {
+	ctx    context.Context
+	opts   SortOptions
+	header []string
+	cmp    *RecordComparator
+	format *Format
+	dir    string
+	runs   []string
+
+	mu  sync.Mutex
+	err error // first error of the run workers
+}
+
+// sortRecords sorts the chunk in place keeping the input order of equal records, keys are extracted once.
+func (t *externalSorter) sortRecords(records []Record) error {
+
+	keys := make([]interface{}, len(records))
+	for i, record := range records {
+		var err error
+		if keys[i], err = t.cmp.Key(record); err != nil {
+			return errors.Errorf("sort key, %v", err)
+		}
+	}
+
+	index := make([]int, len(records))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		return t.cmp.Compare(keys[index[i]], keys[index[j]]) < 0
+	})
+
+	sorted := make([]Record, len(records))
+	for i, j := range index {
+		sorted[i] = records[j]
+	}
+	copy(records, sorted)
+	return nil
+}
+
+func (t *externalSorter) runPath(runNum int) string {
+	ext := ".gz"
+	if len(t.format.Extensions) > 0 {
+		ext = t.format.Extensions[0] + ".gz"
+	}
+	return filepath.Join(t.dir, fmt.Sprintf("run-%06d%s", runNum, ext))
+}
+
+// writeRun writes the records to a gzip run through the Split part writer.
+func (t *externalSorter) writeRun(records []Record, runPath string) error {
+	part, err := newPartWriter(filePartTarget("", func(int) string { return runPath }), 1, t.header)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := part.Write(record); err != nil {
+			part.Close()
+			return err
+		}
+	}
+	return part.Close()
+}
+
+func (t *externalSorter) setErr(err error) {
+	t.mu.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.mu.Unlock()
+}
+
+func (t *externalSorter) failed() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// spill reads the input into chunks of the memory budget, sorts and writes them as runs by parallel workers.
+// Returns the last chunk without writing it when the whole input fits in memory.
+func (t *externalSorter) spill(reader RecordReader, report *SortReport) ([]Record, error) {
+
+	parallel := t.opts.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+	memoryBytes := t.opts.MemoryBytes
+	if memoryBytes <= 0 {
+		memoryBytes = defaultSortMemoryBytes
+	}
+	chunkBytes := memoryBytes / int64(parallel)
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	dispatch := func(chunk []Record) {
+		runPath := t.runPath(len(t.runs) + 1)
+		t.runs = append(t.runs, runPath)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := t.sortRecords(chunk)
+			if err == nil {
+				err = t.writeRun(chunk, runPath)
+			}
+			if err != nil {
+				t.setErr(err)
+			}
+		}()
+	}
+
+	var chunk []Record
+	var size int64
+	sem <- struct{}{}
+
+	for {
+		if err := t.failed(); err != nil {
+			wg.Wait()
+			return nil, err
+		}
+
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			wg.Wait()
+			return nil, err
+		}
+		report.Records++
+
+		chunk = append(chunk, record)
+		size += recordSize(record) + sortRecordOverhead
+		if size >= chunkBytes {
+			dispatch(chunk)
+			chunk, size = nil, 0
+			sem <- struct{}{}
+		}
+	}
+
+	if len(t.runs) == 0 {
+		<-sem
+		return chunk, nil
+	}
+
+	if len(chunk) > 0 {
+		dispatch(chunk)
+	} else {
+		<-sem
+	}
+	wg.Wait()
+	return nil, t.failed()
+}
+
+// mergeRuns merges the runs into the writer, more runs than the fan-in are merged into intermediate runs first.
+func (t *externalSorter) mergeRuns(writer RecordWriter, runs []string) error {
+
+	for len(runs) > sortMaxFanIn {
+		var merged []string
+		for i := 0; i < len(runs); i += sortMaxFanIn {
+			j := i + sortMaxFanIn
+			if j > len(runs) {
+				j = len(runs)
+			}
+			runPath := t.runPath(len(t.runs) + 1)
+			t.runs = append(t.runs, runPath)
+
+			runWriter, err := CreateFile(runPath, t.header)
+			if err != nil {
+				return err
+			}
+			err = t.mergeInto(runWriter, runs[i:j])
+			if closeErr := runWriter.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			merged = append(merged, runPath)
+		}
+		runs = merged
+	}
+
+	return t.mergeInto(writer, runs)
+}
+
+func (t *externalSorter) mergeInto(writer RecordWriter, runs []string) error {
+
+	var readers []RecordReader
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+
+	for _, run := range runs {
+		reader, err := OpenFile(run)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, WithContextReader(t.ctx, reader))
+	}
+
+	_, err := MergeRecords(writer, readers, t.cmp, MergeOptions{})
+	return err
+}
+
+func sortKindFile(ctx context.Context, kind string, inputFilePath, outputFilePath string, opts SortOptions) (report *SortReport, err error) {
+
+	reader, err := openKindFile(kind, inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	inputFormat, _, err := kindFileFormat(kind, inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+	format, gzipEnabled, err := kindFileFormat(kind, outputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &externalSorter{
+		ctx:    ctx,
+		opts:   opts,
+		header: reader.Header(),
+		format: inputFormat,
+	}
+
+	order := opts.Order
+	if len(opts.Keys) > 0 {
+		order = OrderBy(opts.Keys...)
+	}
+	if order == nil {
+		return nil, errors.New("sort keys are not set")
+	}
+	if t.cmp, err = order(t.header); err != nil {
+		return nil, err
+	}
+
+	t.dir, err = ioutil.TempDir(opts.TempDir, "files-sort")
+	if err != nil {
+		return nil, errors.Errorf("can not create temp directory, %v", err)
+	}
+	defer os.RemoveAll(t.dir)
+
+	report = new(SortReport)
+	chunk, err := t.spill(WithContextReader(ctx, reader), report)
+	if err != nil {
+		return nil, err
+	}
+	report.Runs = len(t.runs)
+
+	writer, err := format.CreateFile(outputFilePath, gzipEnabled, t.header)
+	if err != nil {
+		return nil, err
+	}
+
+	if report.Runs == 0 {
+		if err = t.sortRecords(chunk); err == nil {
+			for _, record := range chunk {
+				if err = contextErr(ctx); err != nil {
+					break
+				}
+				if err = writer.Write(record); err != nil {
+					break
+				}
+			}
+		}
+	} else {
+		err = t.mergeRuns(writer, t.runs)
+	}
+
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outputFilePath)
+		return nil, err
+	}
+	return report, nil
+}
+
+// SortFile sorts records of any supported file by external merge sort, the order of equal records is kept.
+func SortFile(inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(context.Background(), "", inputFilePath, outputFilePath, opts)
+}
+
+func SortCsvFile(inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(context.Background(), CsvRecords, inputFilePath, outputFilePath, opts)
+}
+
+func SortJsonFile(inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(context.Background(), JsonRecords, inputFilePath, outputFilePath, opts)
+}
+
+func SortProtoFile(inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(context.Background(), ProtoRecords, inputFilePath, outputFilePath, opts)
+}
+
+// SortFileContext sorts like SortFile and stops with ctx.Err() once the context is done, the output and runs are removed.
+func SortFileContext(ctx context.Context, inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(ctx, "", inputFilePath, outputFilePath, opts)
+}
+
+func SortCsvFileContext(ctx context.Context, inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(ctx, CsvRecords, inputFilePath, outputFilePath, opts)
+}
+
+func SortJsonFileContext(ctx context.Context, inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(ctx, JsonRecords, inputFilePath, outputFilePath, opts)
+}
+
+func SortProtoFileContext(ctx context.Context, inputFilePath, outputFilePath string, opts SortOptions) (*SortReport, error) {
+	return sortKindFile(ctx, ProtoRecords, inputFilePath, outputFilePath, opts)
+}
@@ -0,0 +1,173 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSortCsvFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "sort-test")
+	inputFilePath := filePath + ".csv.gz"
+	outputFilePath := filePath + "_sorted.csv"
+	defer os.Remove(inputFilePath)
+	defer os.Remove(outputFilePath)
+
+	rnd := rand.New(rand.NewSource(1))
+	writer, err := files.CreateFile(inputFilePath, []string{"seq", "group", "value"})
+	require.NoError(t, err)
+	for i := 0; i < 3000; i++ {
+		value := strconv.Itoa(rnd.Intn(20))
+		if i%100 == 0 {
+			value = "n/a"
+		}
+		require.NoError(t, writer.Write(files.Record{Values: []string{strconv.Itoa(i), fmt.Sprintf("g%d", rnd.Intn(5)), value}}))
+	}
+	require.NoError(t, writer.Close())
+
+	opts := files.SortOptions{
+		Keys: []files.SortKey{
+			{Key: files.CsvColumnKey("group")},
+			{Key: files.CsvColumnKey("value"), Descending: true, Numeric: true},
+		},
+		MemoryBytes: 32 * 1024,
+		Parallel:    3,
+	}
+
+	report, err := files.SortCsvFile(inputFilePath, outputFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, int64(3000), report.Records)
+	require.True(t, report.Runs > 3, "runs %d", report.Runs)
+
+	reader, err := files.OpenFile(outputFilePath)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var prev []string
+	cnt := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		cnt++
+		if prev != nil {
+			require.True(t, prev[1] <= record.Values[1])
+			if prev[1] == record.Values[1] {
+				if prev[2] == record.Values[2] {
+					// stable order of equal keys
+					require.True(t, atoi(t, prev[0]) < atoi(t, record.Values[0]))
+				} else if prev[2] != "n/a" {
+					// values that are not numbers go first in descending order
+					require.NotEqual(t, "n/a", record.Values[2])
+					require.True(t, atoi(t, prev[2]) > atoi(t, record.Values[2]), "%v %v", prev, record.Values)
+				}
+			}
+		}
+		prev = record.Values
+	}
+	require.Equal(t, 3000, cnt)
+
+	// in memory sort without runs
+	opts.MemoryBytes = 0
+	report, err = files.SortFile(inputFilePath, outputFilePath, opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Runs)
+}
+
+func TestSortJsonFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "sort-test")
+	inputFilePath := filePath + ".json"
+	outputFilePath := filePath + "_sorted.json.gz"
+	defer os.Remove(inputFilePath)
+	defer os.Remove(outputFilePath)
+
+	writer, err := files.CreateFile(inputFilePath, nil)
+	require.NoError(t, err)
+	for _, name := range []string{"c", "a", "d", "b"} {
+		require.NoError(t, writer.Write(files.Record{Raw: []byte(fmt.Sprintf(`{"name":"%s"}`, name))}))
+	}
+	require.NoError(t, writer.Close())
+
+	_, err = files.SortJsonFile(inputFilePath, outputFilePath, files.SortOptions{
+		Order:       files.OrderByKey(files.JsonPointerKey("/name")),
+		MemoryBytes: 100,
+	})
+	require.NoError(t, err)
+
+	reader, err := files.OpenFile(outputFilePath)
+	require.NoError(t, err)
+	defer reader.Close()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		record, err := reader.Read()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf(`{"name":"%s"}`, name), string(record.Raw))
+	}
+}
+
+// countingKey takes the key from the column, counts the extractions and fails on the "bad" value.
+func countingKey(column string, count *int) files.RecordKey {
+	return func(header []string) (files.KeyExtractor, error) {
+		extractor, err := files.CsvColumnKey(column)(header)
+		if err != nil {
+			return nil, err
+		}
+		return func(record files.Record) (string, error) {
+			*count++
+			key, err := extractor(record)
+			if err == nil && key == "bad" {
+				err = fmt.Errorf("broken key")
+			}
+			return key, err
+		}, nil
+	}
+}
+
+func TestSortKeyErrors(t *testing.T) {
+
+	filePath := tempFilePath(t, "sort-test")
+	inputFilePath := filePath + ".csv"
+	outputFilePath := filePath + "_sorted.csv"
+	defer os.Remove(inputFilePath)
+	defer os.Remove(outputFilePath)
+
+	rows := [][]string{{"c"}, {"a"}, {"d"}, {"b"}, {"e"}}
+	writeCsvPart(t, inputFilePath, []string{"key"}, rows...)
+
+	// keys are extracted once per record
+	var count int
+	_, err := files.SortCsvFile(inputFilePath, outputFilePath, files.SortOptions{Order: files.OrderByKey(countingKey("key", &count))})
+	require.NoError(t, err)
+	require.Equal(t, len(rows), count)
+	require.Equal(t, [][]string{{"key"}, {"a"}, {"b"}, {"c"}, {"d"}, {"e"}}, readCsvRows(t, outputFilePath))
+
+	writeCsvPart(t, inputFilePath, []string{"key"}, append(rows, []string{"bad"})...)
+	for _, memoryBytes := range []int64{0, 100} {
+		_, err = files.SortCsvFile(inputFilePath, outputFilePath, files.SortOptions{
+			Order:       files.OrderByKey(countingKey("key", &count)),
+			MemoryBytes: memoryBytes,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "broken key")
+		_, err = os.Stat(outputFilePath)
+		require.True(t, os.IsNotExist(err))
+	}
+}
+
+func atoi(t *testing.T, s string) int {
+	n, err := strconv.Atoi(s)
+	require.NoError(t, err)
+	return n
+}
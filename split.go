@@ -0,0 +1,537 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type SplitOptions struct {
+	Limit    int              // max records per part, 0 for no limit
+	MaxBytes int64            // max bytes per part, measured on the compressed output for .gz parts, 0 for no limit
+	Manifest string           // path of the manifest written after the split, empty for none
+	Key      RecordKey        // first and last keys of the parts in the manifest, optional
+	Workers  int              // goroutines encoding the parts in parallel variants, 0 for GOMAXPROCS
+	Progress ProgressObserver // reports reading the input, optional
+}
+
+// splitReserve covers the gzip header, trailer and sync markers that are not visible before the flush.
+const splitReserve = 64
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+	h hash.Hash // optional
+}
+
+func (t *countingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.n += int64(n)
+	if t.h != nil {
+		t.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// recordSize is an upper bound of the encoded uncompressed record size.
+func recordSize(record Record) int64 {
+	if record.Values == nil {
+		return int64(len(record.Raw)) + 4 // length prefix or newline with RS
+	}
+	n := int64(len(record.Values))
+	for _, v := range record.Values {
+		n += int64(len(v)) + int64(strings.Count(v, "\"")) + 2 // quotes
+	}
+	return n
+}
+
+// SplitPart describes a written part, Bytes is the size of the part as stored.
+type SplitPart struct {
+	Num     int
+	Path    string
+	Records int64
+	Bytes   int64
+}
+
+type partWriter struct {
+	SplitPart
+	wc           io.WriteCloser
+	counter      *countingWriter
+	w            RecordWriter // nil when the parallel split writes encoded batches
+	recordFormat *Format
+	gzipEnabled  bool
+	format       string
+	pending      int64 // uncompressed bytes written after the last flush
+	first        Record
+	last         Record
+	closed       bool
+}
+
+// partTarget opens the destination of the part, path is empty for the parts that are not local files.
+type partTarget func(partNum int) (wc io.WriteCloser, path string, format *Format, gzipEnabled bool, err error)
+
+func openPart(target partTarget, partNum int) (*partWriter, error) {
+
+	wc, path, format, gzipEnabled, err := target(partNum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &partWriter{
+		SplitPart:    SplitPart{Num: partNum, Path: path},
+		wc:           wc,
+		counter:      &countingWriter{w: wc},
+		recordFormat: format,
+		gzipEnabled:  gzipEnabled,
+		format:       formatName(format, gzipEnabled),
+	}, nil
+}
+
+func newPartWriter(target partTarget, partNum int, header []string) (*partWriter, error) {
+
+	t, err := openPart(target, partNum)
+	if err != nil {
+		return nil, err
+	}
+
+	t.w, err = t.recordFormat.Create(t.counter, t.gzipEnabled, header)
+	if err != nil {
+		t.wc.Close()
+		removePart(t)
+		return nil, err
+	}
+
+	if header != nil {
+		t.pending = recordSize(Record{Values: header})
+	}
+
+	return t, nil
+}
+
+// fits checks that the record does not overflow maxBytes, the exact size is known only after the flush.
+func (t *partWriter) fits(record Record, maxBytes int64) (bool, error) {
+	size := recordSize(record)
+	if t.counter.n+t.pending+t.pending/1000+size+splitReserve <= maxBytes {
+		return true, nil
+	}
+	if t.pending > 0 {
+		if err := t.w.Flush(); err != nil {
+			return false, err
+		}
+		t.pending = 0
+	}
+	return t.counter.n+size+size/1000+splitReserve <= maxBytes, nil
+}
+
+func (t *partWriter) Write(record Record) error {
+	if err := t.w.Write(record); err != nil {
+		return err
+	}
+	if t.Records == 0 {
+		t.first = record
+	}
+	t.last = record
+	t.Records++
+	t.pending += recordSize(record)
+	return nil
+}
+
+func (t *partWriter) Close() (err error) {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if t.w != nil {
+		err = t.w.Close()
+	}
+	if closeErr := t.wc.Close(); err == nil {
+		err = closeErr
+	}
+	t.Bytes = t.counter.n
+	return err
+}
+
+// manifestPart builds the manifest entry of the closed part, the checksum needs enableChecksum before writing.
+func (t *partWriter) manifestPart(key KeyExtractor) (ManifestPart, error) {
+	part := ManifestPart{
+		Path:    t.Path,
+		Records: t.Records,
+		Bytes:   t.Bytes,
+	}
+	if t.counter.h != nil {
+		part.Sha256 = hex.EncodeToString(t.counter.h.Sum(nil))
+	}
+	if key != nil && t.Records > 0 {
+		var err error
+		if part.FirstKey, err = key(t.first); err != nil {
+			return part, err
+		}
+		if part.LastKey, err = key(t.last); err != nil {
+			return part, err
+		}
+	}
+	return part, nil
+}
+
+func (t *partWriter) enableChecksum() {
+	t.counter.h = sha256.New()
+}
+
+type splitter struct {
+	opts   SplitOptions
+	target partTarget
+	parts  []*partWriter
+}
+
+func (t *splitter) split(reader RecordReader) error {
+
+	var writer *partWriter
+	header := reader.Header()
+
+	err := func() error {
+		for {
+
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if writer != nil && writer.Records > 0 {
+				roll := t.opts.Limit > 0 && writer.Records >= int64(t.opts.Limit)
+				if !roll && t.opts.MaxBytes > 0 {
+					fits, err := writer.fits(record, t.opts.MaxBytes)
+					if err != nil {
+						return err
+					}
+					roll = !fits
+				}
+				if roll {
+					err = writer.Close()
+					writer = nil
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if writer == nil {
+				writer, err = newPartWriter(t.target, len(t.parts)+1, header)
+				if err != nil {
+					return err
+				}
+				if t.opts.Manifest != "" {
+					writer.enableChecksum()
+				}
+				t.parts = append(t.parts, writer)
+			}
+
+			if err = writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}()
+
+	if writer != nil {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func (t *splitter) manifest(header []string) (*Manifest, error) {
+
+	var key KeyExtractor
+	if t.opts.Key != nil {
+		var err error
+		if key, err = t.opts.Key(header); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Manifest{Parts: make([]ManifestPart, len(t.parts))}
+	for i, part := range t.parts {
+		var err error
+		if m.Parts[i], err = part.manifestPart(key); err != nil {
+			return nil, err
+		}
+		m.Format = part.format
+		m.Records += part.Records
+	}
+	return m, nil
+}
+
+func (t *splitter) paths() []string {
+	list := make([]string, len(t.parts))
+	for i, part := range t.parts {
+		list[i] = part.Path
+	}
+	return list
+}
+
+func (t *splitter) removeParts() {
+	for _, part := range t.parts {
+		part.Close()
+		removePart(part)
+	}
+}
+
+func removePart(part *partWriter) {
+	if part.Path != "" {
+		os.Remove(part.Path)
+	}
+}
+
+// kindFormatOf returns the registered format of the file if it stores records of the kind, otherwise the default one.
+// The csv kind is comma-only like the legacy constructors, tsv files are handled by the format-neutral functions.
+func kindFormatOf(kind string, filePath string) (*Format, bool) {
+	format, gzipEnabled, err := FormatOf(filePath)
+	if err == nil && format.Kind == kind && kind != CsvRecords {
+		return format, gzipEnabled
+	}
+	gzipEnabled = strings.HasSuffix(filePath, ".gz")
+	switch kind {
+	case CsvRecords:
+		return CsvFormat, gzipEnabled
+	case ProtoRecords:
+		return ProtoFormat, gzipEnabled
+	default:
+		return JsonLinesFormat, gzipEnabled
+	}
+}
+
+type bufferedFile struct {
+	*bufio.Writer
+	fd *os.File
+}
+
+func (t *bufferedFile) Close() error {
+	return closeLayers(t.Flush, syncOnClose(t.fd), t.fd.Close)
+}
+
+func filePartTarget(kind string, partFn func(int) string) partTarget {
+	return func(partNum int) (io.WriteCloser, string, *Format, bool, error) {
+		partFilePath := partFn(partNum)
+		format, gzipEnabled, err := kindFileFormat(kind, partFilePath)
+		if err != nil {
+			return nil, "", nil, false, err
+		}
+		fd, err := createFile(partFilePath)
+		if err != nil {
+			return nil, "", nil, false, errors.Errorf("file create error '%s', %v", partFilePath, err)
+		}
+		return &bufferedFile{bufio.NewWriterSize(fd, FileRWBlockSize), fd}, partFilePath, format, gzipEnabled, nil
+	}
+}
+
+func streamPartTarget(format *Format, gzipEnabled bool, partFn func(partNum int) (io.WriteCloser, error)) partTarget {
+	return func(partNum int) (io.WriteCloser, string, *Format, bool, error) {
+		wc, err := partFn(partNum)
+		if err != nil {
+			return nil, "", nil, false, errors.Errorf("can not create part %d, %v", partNum, err)
+		}
+		return wc, "", format, gzipEnabled, nil
+	}
+}
+
+func kindFileFormat(kind string, filePath string) (*Format, bool, error) {
+	if kind == "" {
+		return FormatOf(filePath)
+	}
+	format, gzipEnabled := kindFormatOf(kind, filePath)
+	return format, gzipEnabled, nil
+}
+
+func openKindFile(kind string, filePath string) (RecordReader, error) {
+	format, gzipEnabled, err := kindFileFormat(kind, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return format.OpenFile(filePath, gzipEnabled)
+}
+
+func splitKindFile(kind string, inputFilePath string, opts SplitOptions, partFn func(int) string, run func(s *splitter, reader RecordReader) error) ([]string, error) {
+
+	format, gzipEnabled, err := kindFileFormat(kind, inputFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(inputFilePath)
+	if err != nil {
+		return nil, errors.Errorf("file open error '%s', %v", inputFilePath, err)
+	}
+	defer fd.Close()
+
+	var src io.Reader = fd
+	sum := sha256.New()
+	if opts.Manifest != "" {
+		src = io.TeeReader(fd, sum)
+	}
+
+	var reader RecordReader
+	if tracker := newFilesProgressTracker(opts.Progress, []string{inputFilePath}); tracker != nil {
+		var pr *progressRecordReader
+		if pr, err = tracker.open(format, src, gzipEnabled); err == nil {
+			pr.eof = true
+			reader = pr
+		}
+	} else {
+		reader, err = format.Open(bufio.NewReaderSize(src, FileRWBlockSize), gzipEnabled)
+	}
+	if err != nil {
+		return nil, errors.Errorf("open %s records in '%s', %v", format.Name, inputFilePath, err)
+	}
+	defer reader.Close()
+
+	s := &splitter{
+		opts:   opts,
+		target: filePartTarget(kind, partFn),
+	}
+
+	err = run(s, reader)
+	if err == nil && opts.Manifest != "" {
+		err = s.writeManifest(reader.Header(), src, sum, inputFilePath, formatName(format, gzipEnabled))
+	}
+
+	if err != nil {
+		s.removeParts()
+		return nil, err
+	}
+
+	return s.paths(), nil
+}
+
+// writeManifest reads the rest of the source to complete its checksum and writes the manifest.
+func (t *splitter) writeManifest(header []string, src io.Reader, sum hash.Hash, inputFilePath, sourceFormat string) error {
+
+	if _, err := io.Copy(ioutil.Discard, src); err != nil {
+		return errors.Errorf("file read error '%s', %v", inputFilePath, err)
+	}
+
+	m, err := t.manifest(header)
+	if err != nil {
+		return err
+	}
+	m.Source = inputFilePath
+	m.SourceSha256 = hex.EncodeToString(sum.Sum(nil))
+	m.SourceFormat = sourceFormat
+
+	return WriteManifest(t.opts.Manifest, m)
+}
+
+// SplitFile splits any supported file into parts of limit records, csv parts repeat the header.
+func SplitFile(inputFilePath string, limit int, partFn func(int) string) ([]string, error) {
+	return splitKindFile("", inputFilePath, SplitOptions{Limit: limit}, partFn, (*splitter).split)
+}
+
+// SplitFileWith splits any supported file by record count and byte size of the parts, formats of parts are selected by extension.
+func SplitFileWith(inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile("", inputFilePath, opts, partFn, (*splitter).split)
+}
+
+func SplitCsvFileWith(inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(CsvRecords, inputFilePath, opts, partFn, (*splitter).split)
+}
+
+func SplitJsonFileWith(inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(JsonRecords, inputFilePath, opts, partFn, (*splitter).split)
+}
+
+func SplitProtoFileWith(inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(ProtoRecords, inputFilePath, opts, partFn, (*splitter).split)
+}
+
+func splitWithContext(ctx context.Context) func(s *splitter, reader RecordReader) error {
+	return func(s *splitter, reader RecordReader) error {
+		return s.split(WithContextReader(ctx, reader))
+	}
+}
+
+// SplitFileContext splits like SplitFileWith, once the context is done it removes the written parts and returns ctx.Err().
+func SplitFileContext(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile("", inputFilePath, opts, partFn, splitWithContext(ctx))
+}
+
+func SplitCsvFileContext(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(CsvRecords, inputFilePath, opts, partFn, splitWithContext(ctx))
+}
+
+func SplitJsonFileContext(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(JsonRecords, inputFilePath, opts, partFn, splitWithContext(ctx))
+}
+
+func SplitProtoFileContext(ctx context.Context, inputFilePath string, opts SplitOptions, partFn func(int) string) ([]string, error) {
+	return splitKindFile(ProtoRecords, inputFilePath, opts, partFn, splitWithContext(ctx))
+}
+
+// SplitStream splits the records of the format read from r into parts of the same format created by partFn,
+// like uploads to an object storage. On error the parts created so far, the last one incomplete, are returned
+// with it, since they are left to the caller to clean up.
+func SplitStream(r io.Reader, format *Format, gzipEnabled bool, opts SplitOptions, partFn func(partNum int) (io.WriteCloser, error)) ([]SplitPart, error) {
+	return SplitStreamContext(context.Background(), r, format, gzipEnabled, opts, partFn)
+}
+
+// SplitStreamContext splits like SplitStream and stops with ctx.Err() once the context is done.
+func SplitStreamContext(ctx context.Context, r io.Reader, format *Format, gzipEnabled bool, opts SplitOptions, partFn func(partNum int) (io.WriteCloser, error)) ([]SplitPart, error) {
+
+	if opts.Manifest != "" {
+		return nil, errors.New("split manifest needs part files")
+	}
+
+	var reader RecordReader
+	var err error
+	if opts.Progress != nil {
+		var pr *progressRecordReader
+		if pr, err = newProgressTracker(opts.Progress, 0).open(format, r, gzipEnabled); err == nil {
+			pr.eof = true
+			reader = pr
+		}
+	} else {
+		reader, err = format.Open(r, gzipEnabled)
+	}
+	if err != nil {
+		return nil, errors.Errorf("open %s records, %v", format.Name, err)
+	}
+	defer reader.Close()
+
+	s := &splitter{
+		opts:   opts,
+		target: streamPartTarget(format, gzipEnabled, partFn),
+	}
+
+	err = s.split(WithContextReader(ctx, reader))
+
+	list := make([]SplitPart, len(s.parts))
+	for i, part := range s.parts {
+		list[i] = part.SplitPart
+	}
+	return list, err
+}
+
+func SplitCsvStream(r io.Reader, gzipEnabled bool, opts SplitOptions, partFn func(partNum int) (io.WriteCloser, error)) ([]SplitPart, error) {
+	return SplitStream(r, CsvFormat, gzipEnabled, opts, partFn)
+}
+
+func SplitJsonStream(r io.Reader, gzipEnabled bool, opts SplitOptions, partFn func(partNum int) (io.WriteCloser, error)) ([]SplitPart, error) {
+	return SplitStream(r, JsonLinesFormat, gzipEnabled, opts, partFn)
+}
+
+func SplitProtoStream(r io.Reader, gzipEnabled bool, opts SplitOptions, partFn func(partNum int) (io.WriteCloser, error)) ([]SplitPart, error) {
+	return SplitStream(r, ProtoFormat, gzipEnabled, opts, partFn)
+}
@@ -0,0 +1,279 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSplitAndJoinFile(t *testing.T) {
+
+	filePath := tempFilePath(t, "formats-test")
+	csvFilePath := filePath + ".tsv.gz"
+	defer os.Remove(csvFilePath)
+
+	writer, err := files.CreateFile(csvFilePath, []string{"name", "count"})
+	require.NoError(t, err)
+	for i := 0; i < 25; i++ {
+		require.NoError(t, writer.Write(files.Record{Values: []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}}))
+	}
+	require.NoError(t, writer.Close())
+
+	parts, err := files.SplitFile(csvFilePath, 10, func(i int) string {
+		return fmt.Sprintf("%s_part%d.tsv", filePath, i)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(parts))
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+
+	cnt, err := files.CountFile(parts[2])
+	require.NoError(t, err)
+	require.Equal(t, int64(5), cnt)
+
+	joinedFilePath := filePath + "_joined.tsv.gz"
+	defer os.Remove(joinedFilePath)
+
+	err = files.JoinFiles(joinedFilePath, parts)
+	require.NoError(t, err)
+
+	reader, err := files.OpenFile(joinedFilePath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"name", "count"}, reader.Header())
+	for i := 0; i < 25; i++ {
+		record, err := reader.Read()
+		require.NoError(t, err)
+		require.Equal(t, []string{fmt.Sprintf("name%d", i), strconv.Itoa(i)}, record.Values)
+	}
+	_, err = reader.Read()
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, reader.Close())
+}
+
+func writeRandomCsv(t *testing.T, filePath string, rows int) {
+	rnd := rand.New(rand.NewSource(1))
+	writer, err := files.CreateFile(filePath, []string{"id", "payload"})
+	require.NoError(t, err)
+	buf := make([]byte, 40)
+	for i := 0; i < rows; i++ {
+		rnd.Read(buf)
+		require.NoError(t, writer.Write(files.Record{Values: []string{strconv.Itoa(i), hex.EncodeToString(buf)}}))
+	}
+	require.NoError(t, writer.Close())
+}
+
+func TestSplitFileMaxBytes(t *testing.T) {
+
+	for _, ext := range []string{".csv", ".csv.gz"} {
+
+		filePath := tempFilePath(t, "split-test")
+		inputFilePath := filePath + ext
+		writeRandomCsv(t, inputFilePath, 1000)
+
+		opts := files.SplitOptions{MaxBytes: 16 * 1024}
+		parts, err := files.SplitFileWith(inputFilePath, opts, func(i int) string {
+			return fmt.Sprintf("%s_part%d%s", filePath, i, ext)
+		})
+		require.NoError(t, err, ext)
+		require.True(t, len(parts) > 2, ext)
+
+		var total int64
+		for _, part := range parts {
+			fi, err := os.Stat(part)
+			require.NoError(t, err)
+			require.True(t, fi.Size() <= opts.MaxBytes, "%s size %d", part, fi.Size())
+
+			reader, err := files.OpenFile(part)
+			require.NoError(t, err)
+			require.Equal(t, []string{"id", "payload"}, reader.Header())
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				require.Equal(t, strconv.FormatInt(total, 10), record.Values[0])
+				total++
+			}
+			reader.Close()
+			os.Remove(part)
+		}
+		require.Equal(t, int64(1000), total, ext)
+		os.Remove(inputFilePath)
+	}
+}
+
+func TestSplitFileMaxBytesAndLimit(t *testing.T) {
+
+	filePath := tempFilePath(t, "split-test")
+	inputFilePath := filePath + ".jsonl"
+	defer os.Remove(inputFilePath)
+
+	writer, err := files.CreateFile(inputFilePath, nil)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, writer.Write(files.Record{Raw: []byte(fmt.Sprintf(`{"id":%d}`, 1000+i))}))
+	}
+	require.NoError(t, writer.Close())
+
+	// 10 records of 12 bytes fit in 200 bytes, so the limit rolls the parts first
+	parts, err := files.SplitJsonFileWith(inputFilePath, files.SplitOptions{Limit: 10, MaxBytes: 200}, func(i int) string {
+		return fmt.Sprintf("%s_part%d.jsonl", filePath, i)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, len(parts))
+
+	// a record larger than the limit still goes to its own part
+	parts2, err := files.SplitJsonFileWith(inputFilePath, files.SplitOptions{Limit: 10, MaxBytes: 10}, func(i int) string {
+		return fmt.Sprintf("%s_small%d.jsonl", filePath, i)
+	})
+	require.NoError(t, err)
+	require.Equal(t, 100, len(parts2))
+
+	for _, part := range append(parts, parts2...) {
+		os.Remove(part)
+	}
+}
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (t *bufferCloser) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestSplitAndJoinStream(t *testing.T) {
+
+	var input bytes.Buffer
+	writer := files.NewProtoStream(&input, true)
+	for i := 0; i < 25; i++ {
+		_, err := writer.Write(&Domain{Domain: fmt.Sprintf("www%d.example.com", i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	var buffers []*bufferCloser
+	parts, err := files.SplitProtoStream(&input, true, files.SplitOptions{Limit: 10}, func(partNum int) (io.WriteCloser, error) {
+		require.Equal(t, len(buffers)+1, partNum)
+		buffers = append(buffers, new(bufferCloser))
+		return buffers[partNum-1], nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(parts))
+	for i, part := range parts {
+		require.True(t, buffers[i].closed)
+		require.Equal(t, int64(buffers[i].Len()), part.Bytes)
+		require.Equal(t, "", part.Path)
+	}
+	require.Equal(t, int64(5), parts[2].Records)
+
+	var output bytes.Buffer
+	err = files.JoinProtoStream(&output, false, len(buffers), func(partNum int) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buffers[partNum-1].Bytes())), nil
+	}, files.JoinOptions{})
+	require.Error(t, err, "parts are compressed")
+
+	output.Reset()
+	err = files.JoinProtoStream(&output, true, len(buffers), func(partNum int) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buffers[partNum-1].Bytes())), nil
+	}, files.JoinOptions{})
+	require.NoError(t, err)
+
+	reader, err := files.ProtoStream(&output, true)
+	require.NoError(t, err)
+	for i := 0; i < 25; i++ {
+		domain := new(Domain)
+		require.NoError(t, reader.ReadTo(domain))
+		require.Equal(t, fmt.Sprintf("www%d.example.com", i), domain.Domain)
+	}
+	require.Equal(t, io.EOF, reader.ReadTo(new(Domain)))
+
+	// no parts make a valid empty gzip stream
+	for _, format := range []*files.Format{files.CsvFormat, files.JsonLinesFormat, files.ProtoFormat} {
+		output.Reset()
+		err = files.JoinStream(&output, format, true, 0, nil, files.JoinOptions{})
+		require.NoError(t, err, format.Name)
+		require.True(t, output.Len() > 0, format.Name)
+
+		records, err := format.Open(&output, true)
+		require.NoError(t, err, format.Name)
+		_, err = records.Read()
+		require.Equal(t, io.EOF, err, format.Name)
+		require.NoError(t, records.Close())
+	}
+}
+
+func TestSplitStreamReturnsPartsOnError(t *testing.T) {
+
+	var input bytes.Buffer
+	writer := files.NewCsvStream(&input, false)
+	require.NoError(t, writer.Write("name"))
+	for i := 0; i < 25; i++ {
+		require.NoError(t, writer.Write(fmt.Sprintf("name%d", i)))
+	}
+	require.NoError(t, writer.Close())
+
+	var buffers []*bufferCloser
+	parts, err := files.SplitCsvStream(&input, false, files.SplitOptions{Limit: 10}, func(partNum int) (io.WriteCloser, error) {
+		if partNum == 3 {
+			return nil, io.ErrClosedPipe
+		}
+		buffers = append(buffers, new(bufferCloser))
+		return buffers[partNum-1], nil
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, len(parts))
+	for i, part := range parts {
+		require.Equal(t, i+1, part.Num)
+		require.Equal(t, int64(10), part.Records)
+		require.True(t, buffers[i].closed)
+	}
+
+	// the part that fails on write is returned too
+	input.Reset()
+	writer = files.NewCsvStream(&input, false)
+	require.NoError(t, writer.Write("name"))
+	for i := 0; i < 25; i++ {
+		require.NoError(t, writer.Write(fmt.Sprintf("name%d", i)))
+	}
+	require.NoError(t, writer.Close())
+
+	parts, err = files.SplitCsvStream(&input, false, files.SplitOptions{Limit: 10}, func(partNum int) (io.WriteCloser, error) {
+		if partNum == 2 {
+			return &failingWriteCloser{}, nil
+		}
+		return new(bufferCloser), nil
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, len(parts))
+	require.Equal(t, 2, parts[1].Num)
+}
+
+type failingWriteCloser struct {
+}
+
+func (t *failingWriteCloser) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func (t *failingWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// createFile creates the file like os.Create, the parent directory is synced when SyncDir is set.
+func createFile(filePath string) (*os.File, error) {
+	fd, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncCreated(filePath); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return fd, nil
+}
+
+// syncCreated makes the directory entry of the created or renamed file durable when SyncDir is set.
+func syncCreated(filePath string) error {
+	if !SyncDir || runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(filePath))
+	if err != nil {
+		return err
+	}
+	return closeLayers(dir.Sync, dir.Close)
+}
+
+// syncOnClose is the fsync step of Close enabled by SyncOnClose.
+func syncOnClose(fd *os.File) func() error {
+	if !SyncOnClose {
+		return noStep
+	}
+	return fd.Sync
+}
+
+// writerSync is the fsync step of stream writers, it syncs the underlying writer if it is a file.
+func writerSync(w io.Writer) func() error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		return s.Sync
+	}
+	return noStep
+}
@@ -0,0 +1,188 @@
+/**
+  Copyright (c) 2022 Arpabet, LLC. All rights reserved.
+*/
+
+package files_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"go.arpabet.com/files"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// crashCopy returns the file as left by a crash in the middle of writing the data after the sync point.
+func crashCopy(t *testing.T, filePath string, syncSize int64) []byte {
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.True(t, int64(len(content)) > syncSize)
+	return content[:syncSize+(int64(len(content))-syncSize)/2]
+}
+
+func fileSize(t *testing.T, filePath string) int64 {
+	fi, err := os.Stat(filePath)
+	require.NoError(t, err)
+	return fi.Size()
+}
+
+// gunzipPartial decodes the truncated gzip content up to the point where it was cut.
+func gunzipPartial(t *testing.T, content []byte) []byte {
+	gzr, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(gzr)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	return data
+}
+
+func TestSyncPointSurvivesPartialWrite(t *testing.T) {
+
+	for _, ext := range []string{".csv", ".csv.gz", ".jsonl", ".jsonl.gz", ".pb", ".pb.gz"} {
+
+		filePath := tempFilePath(t, "sync-test") + ext
+		defer os.Remove(filePath)
+
+		var synced bytes.Buffer
+		var writeRecord func(i int) error
+		var writer interface {
+			Flush() error
+			Sync() error
+			Close() error
+		}
+
+		switch ext {
+		case ".csv", ".csv.gz":
+			w, err := files.NewCsvFile(filePath)
+			require.NoError(t, err)
+			writer = w
+			writeRecord = func(i int) error { return w.Write("row", fmt.Sprint(i)) }
+		case ".jsonl", ".jsonl.gz":
+			w, err := files.NewJsonFile(filePath)
+			require.NoError(t, err)
+			writer = w
+			writeRecord = func(i int) error { return w.WriteRaw([]byte(fmt.Sprintf(`{"row":%d}`, i))) }
+		default:
+			w, err := files.NewProtoFile(filePath)
+			require.NoError(t, err)
+			writer = w
+			writeRecord = func(i int) error { return w.WriteRaw([]byte(fmt.Sprintf("row %d", i))) }
+		}
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, writeRecord(i))
+		}
+		require.NoError(t, writer.Sync(), ext)
+		syncSize := fileSize(t, filePath)
+
+		for i := 100; i < 1000; i++ {
+			require.NoError(t, writeRecord(i))
+		}
+		require.NoError(t, writer.Flush())
+
+		content := crashCopy(t, filePath, syncSize)
+		if ext == ".csv.gz" || ext == ".jsonl.gz" || ext == ".pb.gz" {
+			content = gunzipPartial(t, content)
+		}
+
+		switch ext {
+		case ".csv", ".csv.gz":
+			for i := 0; i < 100; i++ {
+				fmt.Fprintf(&synced, "row,%d\n", i)
+			}
+		case ".jsonl", ".jsonl.gz":
+			for i := 0; i < 100; i++ {
+				fmt.Fprintf(&synced, "{\"row\":%d}\n", i)
+			}
+		default:
+			for i := 0; i < 100; i++ {
+				require.NoError(t, files.ProtobufWriteRaw(&synced, []byte(fmt.Sprintf("row %d", i))))
+			}
+		}
+		require.True(t, bytes.HasPrefix(content, synced.Bytes()), ext)
+		require.NoError(t, writer.Close())
+	}
+}
+
+func TestStreamSync(t *testing.T) {
+
+	filePath := tempFilePath(t, "sync-test") + ".jsonl.gz"
+	defer os.Remove(filePath)
+
+	fd, err := os.Create(filePath)
+	require.NoError(t, err)
+
+	writer := files.NewJsonStream(fd, true)
+	require.NoError(t, writer.WriteRaw([]byte("{}")))
+	require.NoError(t, writer.Sync())
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, []byte("{}\n"), gunzipPartial(t, content))
+	require.NoError(t, writer.Close())
+	require.NoError(t, fd.Close())
+
+	failure := errors.New("write failed")
+
+	csvWriter := files.NewCsvStream(&failingWriter{failure}, true)
+	require.NoError(t, csvWriter.Write("a"))
+	require.Equal(t, failure, csvWriter.Sync())
+
+	protoWriter := files.NewProtoStream(&failingWriter{failure}, false)
+	require.NoError(t, protoWriter.WriteRaw([]byte{1}))
+	require.Equal(t, failure, protoWriter.Sync())
+}
+
+func TestSyncDir(t *testing.T) {
+
+	files.SyncDir, files.SyncOnClose = true, true
+	defer func() {
+		files.SyncDir, files.SyncOnClose = false, false
+	}()
+
+	filePath := tempFilePath(t, "sync-test") + ".csv"
+	defer os.Remove(filePath)
+
+	writer, err := files.NewCsvFile(filePath)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write("a", "1"))
+	require.NoError(t, writer.Close())
+
+	appender, err := files.AppendCsvFile(filePath, nil)
+	require.NoError(t, err)
+	require.NoError(t, appender.Write("b", "2"))
+	require.NoError(t, appender.Sync())
+	require.NoError(t, appender.Close())
+
+	atomicPath := tempFilePath(t, "sync-test") + ".pb.gz"
+	defer os.Remove(atomicPath)
+
+	atomicWriter, err := files.NewAtomicProtoFile(atomicPath)
+	require.NoError(t, err)
+	require.NoError(t, atomicWriter.WriteRaw([]byte{1}))
+	require.NoError(t, atomicWriter.Sync())
+	require.NoError(t, atomicWriter.Commit())
+
+	progressPath := tempFilePath(t, "sync-test") + ".jsonl.gz"
+	defer os.Remove(progressPath)
+
+	progressWriter, err := files.CreateFileProgress(progressPath, nil, files.ProgressFunc(func(p files.Progress) {}))
+	require.NoError(t, err)
+	require.NoError(t, progressWriter.Write(files.Record{Raw: []byte("{}")}))
+	require.NoError(t, progressWriter.Close())
+
+	content, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	require.Equal(t, "a,1\nb,2\n", string(content))
+
+	count, err := files.CountFile(atomicPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	count, err = files.CountFile(progressPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+}